@@ -0,0 +1,191 @@
+package blinding
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/yunwilliamyu/contact-trace-mixnet/blinding/pb"
+	"sync"
+	"time"
+)
+
+// DefaultAttestationWindow is how far the Timestamp of attested requests may
+// be from the server's clock, see Blinder.AttestationWindow.
+const DefaultAttestationWindow = 5 * time.Minute
+
+// Attestation is the envelope in which a device proves, with its OS's
+// device verification mechanism, that it made a request.
+type Attestation struct {
+	MobileOS string
+	// SignedNonce is the OS-specific signature over the request's Digest.
+	SignedNonce []byte
+	// Certificate optionally carries the OS-specific credential that
+	// SignedNonce is checked against.
+	Certificate []byte `json:",omitempty"`
+}
+
+// Digest is the hash of the request, without its attestation, that devices
+// sign. It does not depend on the encoding of the request: a request sent as
+// JSON and as protobuf have the same digest. It is nil if the request is
+// malformed.
+func (r *BlindingRequest) Digest() []byte {
+	pr, err := r.toProto()
+	if err != nil {
+		return nil
+	}
+	return ProtoDigest(pr)
+}
+
+// ProtoDigest is the digest of a protobuf request, see BlindingRequest.Digest.
+func ProtoDigest(r *pb.BlindRequest) []byte {
+	h := sha256.New()
+	var buf [binary.MaxVarintLen64]byte
+	writeInt := func(v int64) {
+		h.Write(buf[:binary.PutVarint(buf[:], v)])
+	}
+	writeBytes := func(b []byte) {
+		writeInt(int64(len(b)))
+		h.Write(b)
+	}
+	h.Write([]byte("BLINDING_REQUEST_V0"))
+	writeInt(r.Timestamp)
+	writeInt(r.DayId)
+	if r.Ordered {
+		writeInt(1)
+	} else {
+		writeInt(0)
+	}
+	writeInt(int64(len(r.Inputs)))
+	for _, input := range r.Inputs {
+		writeBytes(input)
+	}
+	writeInt(int64(len(r.Tags)))
+	for _, tag := range r.Tags {
+		writeBytes([]byte(tag))
+	}
+	return h.Sum(nil)
+}
+
+type AttestationVerifier interface {
+	// Verify checks that att attests the request with the given digest,
+	// and returns an identifier of the attested device. att is nil for
+	// unattested requests.
+	Verify(digest []byte, att *Attestation) (clientID string, err error)
+}
+
+// AttestationVerifiers dispatches to the verifier of the attestation's
+// MobileOS.
+type AttestationVerifiers map[string]AttestationVerifier
+
+func (avs AttestationVerifiers) Verify(digest []byte, att *Attestation) (string, error) {
+	if att == nil {
+		return "", ErrNotAttested
+	}
+	av, ok := avs[att.MobileOS]
+	if !ok {
+		return "", fmt.Errorf("unsupported mobile OS %q", att.MobileOS)
+	}
+	clientID, err := av.Verify(digest, att)
+	if err != nil {
+		return "", err
+	}
+	return att.MobileOS + ":" + clientID, nil
+}
+
+// NoAttestation accepts all requests. It is only meant for testing.
+type NoAttestation struct{}
+
+func (NoAttestation) Verify(digest []byte, att *Attestation) (string, error) {
+	return "", nil
+}
+
+const ed25519CertContext = "BLINDING_DEVICE_CERT"
+
+// Ed25519Verifier checks attestations signed by device keys certified by a
+// local Ed25519 CA. Certificate is the device public key followed by the CA's
+// signature of it, and SignedNonce is the device's signature of the digest.
+type Ed25519Verifier struct {
+	CAPublicKey ed25519.PublicKey
+}
+
+func (ev Ed25519Verifier) Verify(digest []byte, att *Attestation) (string, error) {
+	if att == nil {
+		return "", ErrNotAttested
+	}
+	if len(att.Certificate) != ed25519.PublicKeySize+ed25519.SignatureSize {
+		return "", errors.New("invalid certificate length")
+	}
+	devicePublicKey := ed25519.PublicKey(att.Certificate[:ed25519.PublicKeySize])
+	caSignature := att.Certificate[ed25519.PublicKeySize:]
+	if !ed25519.Verify(ev.CAPublicKey, append([]byte(ed25519CertContext), devicePublicKey...), caSignature) {
+		return "", errors.New("device certificate is not signed by the CA")
+	}
+	if !ed25519.Verify(devicePublicKey, digest, att.SignedNonce) {
+		return "", errors.New("invalid request signature")
+	}
+	return hex.EncodeToString(devicePublicKey), nil
+}
+
+// Ed25519Certify issues a device certificate accepted by Ed25519Verifier.
+func Ed25519Certify(caPrivateKey ed25519.PrivateKey, devicePublicKey ed25519.PublicKey) []byte {
+	caSignature := ed25519.Sign(caPrivateKey, append([]byte(ed25519CertContext), devicePublicKey...))
+	return append(append([]byte(nil), devicePublicKey...), caSignature...)
+}
+
+// Ed25519Attest timestamps r and attests it with a device key certified by
+// Ed25519Certify.
+func Ed25519Attest(r *BlindingRequest, certificate []byte, devicePrivateKey ed25519.PrivateKey) {
+	r.Timestamp = time.Now().Unix()
+	r.Attestation = Ed25519Attestation(r.Digest(), certificate, devicePrivateKey)
+}
+
+// Ed25519Attestation attests the request with the given digest with a device
+// key certified by Ed25519Certify.
+func Ed25519Attestation(digest []byte, certificate []byte, devicePrivateKey ed25519.PrivateKey) *Attestation {
+	return &Attestation{
+		MobileOS:    "ed25519",
+		SignedNonce: ed25519.Sign(devicePrivateKey, digest),
+		Certificate: certificate,
+	}
+}
+
+// replayCache remembers the digests of attested requests until their
+// timestamp leaves the window, so that each of them is only served once.
+type replayCache struct {
+	seen     map[string]time.Time // expiry by digest
+	lastReap time.Time
+	mu       sync.Mutex
+}
+
+// check returns false if digest was seen before, and remembers it until
+// expiry otherwise.
+func (rc *replayCache) check(digest []byte, expiry time.Time, now time.Time) bool {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.seen == nil {
+		rc.seen = make(map[string]time.Time)
+	}
+	if now.Sub(rc.lastReap) >= time.Minute {
+		for d, e := range rc.seen {
+			if now.After(e) {
+				delete(rc.seen, d)
+			}
+		}
+		rc.lastReap = now
+	}
+	if _, ok := rc.seen[string(digest)]; ok {
+		return false
+	}
+	rc.seen[string(digest)] = expiry
+	return true
+}
+
+// forget removes digest, so that check accepts it again.
+func (rc *replayCache) forget(digest []byte) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	delete(rc.seen, string(digest))
+}
@@ -0,0 +1,107 @@
+package blinding
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestEd25519Attestation(t *testing.T) {
+	caPublicKey, caPrivateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	devicePublicKey, devicePrivateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := Ed25519Certify(caPrivateKey, devicePublicKey)
+	avs := AttestationVerifiers{"ed25519": Ed25519Verifier{CAPublicKey: caPublicKey}}
+
+	r := &BlindingRequest{DayID: 1, Inputs: []string{"00", "01"}}
+	if _, err := avs.Verify(r.Digest(), r.Attestation); err != ErrNotAttested {
+		t.Errorf("unattested request: got %v, want %v", err, ErrNotAttested)
+	}
+
+	Ed25519Attest(r, cert, devicePrivateKey)
+	if _, err := avs.Verify(r.Digest(), r.Attestation); err != nil {
+		t.Errorf("attested request: %s", err.Error())
+	}
+
+	r.Inputs[1] = "02"
+	if _, err := avs.Verify(r.Digest(), r.Attestation); err == nil {
+		t.Errorf("modified request was accepted")
+	}
+
+	_, otherCAPrivateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Ed25519Attest(r, Ed25519Certify(otherCAPrivateKey, devicePublicKey), devicePrivateKey)
+	if _, err := avs.Verify(r.Digest(), r.Attestation); err == nil {
+		t.Errorf("device certified by another CA was accepted")
+	}
+}
+
+type missingKeys struct{}
+
+func (missingKeys) ReadKey(dayID int) (*BlindingKey, error) {
+	return nil, os.ErrNotExist
+}
+
+// flakyKeys fails to read keys while fail is set.
+type flakyKeys struct {
+	fail bool
+}
+
+func (fk *flakyKeys) ReadKey(dayID int) (*BlindingKey, error) {
+	if fk.fail {
+		return nil, errors.New("key store unavailable")
+	}
+	return NewBlindingKey("test"), nil
+}
+
+func TestAttestationReplay(t *testing.T) {
+	caPublicKey, caPrivateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	devicePublicKey, devicePrivateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := Ed25519Certify(caPrivateKey, devicePublicKey)
+	keys := &flakyKeys{fail: true}
+	b := New(keys)
+	b.Verifier = AttestationVerifiers{"ed25519": Ed25519Verifier{CAPublicKey: caPublicKey}}
+
+	r := &BlindingRequest{DayID: 1, Inputs: testInputs[:1]}
+	Ed25519Attest(r, cert, devicePrivateKey)
+	pr, err := r.toProto()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.blind(pr, "127.0.0.1:1"); err != ErrInternal {
+		t.Errorf("request without key: got %v, want %v", err, ErrInternal)
+	}
+	// failed requests can be retried
+	keys.fail = false
+	b.EvictBefore(r.DayID + 1)
+	if _, err := b.blind(pr, "127.0.0.1:1"); err != nil {
+		t.Errorf("retried request: %s", err.Error())
+	}
+	if _, err := b.blind(pr, "127.0.0.2:1"); err == nil || err.(*Error).Code != ErrStaleAttestation.Code {
+		t.Errorf("replayed request: got %v, want %v", err, ErrStaleAttestation)
+	}
+
+	r.Timestamp = time.Now().Add(-2 * DefaultAttestationWindow).Unix()
+	r.Attestation = Ed25519Attestation(r.Digest(), cert, devicePrivateKey)
+	if pr, err = r.toProto(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.blind(pr, "127.0.0.1:1"); err == nil || err.(*Error).Code != ErrStaleAttestation.Code {
+		t.Errorf("old request: got %v, want %v", err, ErrStaleAttestation)
+	}
+}
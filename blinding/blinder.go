@@ -4,16 +4,18 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
-	"errors"
-	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/yunwilliamyu/contact-trace-mixnet/blinding/pb"
 	"golang.org/x/crypto/hkdf"
 	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
-	"path/filepath"
+	"os"
 	"sort"
 	"sync"
+	"time"
 	"unsafe"
 )
 
@@ -49,17 +51,33 @@ func (bk *BlindingKey) permute(values [][]byte) {
 
 func (bk *BlindingKey) exponentiate(input []byte) ([]byte, error) {
 	if len(input) != C.crypto_core_ristretto255_BYTES {
-		return nil, errors.New("invalid length of curve point")
+		return nil, ErrInvalidLength
 	}
 	output := make([]byte, C.crypto_core_ristretto255_BYTES)
 	ret := C.crypto_scalarmult_ristretto255((*C.uchar)(unsafe.Pointer(&output[0])), (*C.uchar)(unsafe.Pointer(&bk.blindingKey[0])), (*C.uchar)(unsafe.Pointer(&input[0])))
 	if ret < 0 {
-		return nil, errors.New("point not on curve")
+		return nil, ErrNotOnCurve
 	}
 	return output, nil
 }
 
+// Blind exponentiates every value with the blinding key and shuffles the
+// results, so that outputs cannot be linked to inputs.
 func (bk *BlindingKey) Blind(values [][]byte) error {
+	if err := bk.blind(values); err != nil {
+		return err
+	}
+	bk.permute(values)
+	return nil
+}
+
+// BlindInOrder is like Blind, but leaves values[i] corresponding to the i-th
+// input. Only use it when the caller is allowed to link outputs to inputs.
+func (bk *BlindingKey) BlindInOrder(values [][]byte) error {
+	return bk.blind(values)
+}
+
+func (bk *BlindingKey) blind(values [][]byte) error {
 	sInputs := make([]string, len(values))
 	for i, v := range values {
 		sInputs[i] = string(v)
@@ -67,7 +85,7 @@ func (bk *BlindingKey) Blind(values [][]byte) error {
 	sort.Strings(sInputs)
 	for i := 0; i < len(sInputs)-1; i++ {
 		if sInputs[i] == sInputs[i+1] {
-			return errors.New("inputs are not distinct")
+			return ErrDuplicateInputs
 		}
 	}
 	for i := range values {
@@ -77,73 +95,303 @@ func (bk *BlindingKey) Blind(values [][]byte) error {
 			return err
 		}
 	}
-	bk.permute(values)
 	return nil
 }
 
+// failedKeyCacheTime is how long a failure to load a key is remembered, so
+// that requests for a missing key do not hit the key source every time.
+const failedKeyCacheTime = 10 * time.Second
+
+// keyEntry is a cached key, or a key that is being loaded. ready is closed
+// once key and err are set.
+type keyEntry struct {
+	ready    chan struct{}
+	key      *BlindingKey
+	err      error
+	loadedAt time.Time
+}
+
+func (e *keyEntry) failureExpired(now time.Time) bool {
+	select {
+	case <-e.ready:
+		return e.err != nil && now.Sub(e.loadedAt) >= failedKeyCacheTime
+	default:
+		return false
+	}
+}
+
 type Blinder struct {
-	keyReader func(int) (*BlindingKey, error)
+	keySource KeySource
+	// CheckDay, if set, is consulted before serving any day, including
+	// days whose keys are already cached.
+	CheckDay func(int) error
+	// Verifier checks the attestation of requests. If it is nil, all
+	// requests are rejected.
+	Verifier AttestationVerifier
+	// AttestationWindow is how far the Timestamp of attested requests may be
+	// from now, DefaultAttestationWindow if not set. Each attested request is
+	// only accepted once.
+	AttestationWindow time.Duration
+	// MaxInputs, if positive, limits the number of inputs per request.
+	MaxInputs int
+	// Limiter, if set, limits the requests per attested client, or per IP
+	// address if the verifier does not identify clients.
+	Limiter *RateLimiter
 
-	keys map[int]*BlindingKey
+	keys map[int]*keyEntry
 	mu   sync.Mutex
+
+	replays replayCache
 }
 
+// KeyForDay returns the key for dayID, loading it if needed. Concurrent
+// calls for the same day share a single load, without blocking other days.
 func (b *Blinder) KeyForDay(dayID int) (*BlindingKey, error) {
+	if b.CheckDay != nil {
+		if err := b.CheckDay(dayID); err != nil {
+			return nil, err
+		}
+	}
 	b.mu.Lock()
-	defer b.mu.Unlock()
-	if k, ok := b.keys[dayID]; ok {
-		return k, nil
+	e, ok := b.keys[dayID]
+	if ok && e.failureExpired(time.Now()) {
+		ok = false
 	}
-	// TODO: don't hold the lock while reading a key
-	k, err := b.keyReader(dayID)
-	if err != nil {
-		return nil, err
+	if ok {
+		b.mu.Unlock()
+		<-e.ready
+		return e.key, e.err
+	}
+	e = &keyEntry{ready: make(chan struct{})}
+	b.keys[dayID] = e
+	b.mu.Unlock()
+
+	e.key, e.err = b.keySource.ReadKey(dayID)
+	e.loadedAt = time.Now()
+	close(e.ready)
+	return e.key, e.err
+}
+
+// EvictBefore drops cached keys of all days before dayID.
+func (b *Blinder) EvictBefore(dayID int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for d := range b.keys {
+		if d < dayID {
+			delete(b.keys, d)
+		}
 	}
-	b.keys[dayID] = k
-	return k, nil
 }
 
 type BlindingRequest struct {
 	DayID  int
 	Inputs []string
+	// Ordered requests outputs in the same order as Inputs. By default
+	// outputs are shuffled and cannot be linked to inputs.
+	Ordered bool `json:",omitempty"`
+	// Tags are opaque client-chosen strings, one per input, echoed back
+	// alongside the outputs. Only allowed for Ordered requests.
+	Tags []string `json:",omitempty"`
+
+	// Timestamp is the unix time in seconds at which the request was
+	// attested.
+	Timestamp   int64        `json:",omitempty"`
+	Attestation *Attestation `json:",omitempty"`
+}
+
+func (r *BlindingRequest) toProto() (*pb.BlindRequest, error) {
+	pr := &pb.BlindRequest{
+		DayId:     int64(r.DayID),
+		Inputs:    make([][]byte, len(r.Inputs)),
+		Ordered:   r.Ordered,
+		Tags:      r.Tags,
+		Timestamp: r.Timestamp,
+	}
+	for i, s := range r.Inputs {
+		var err error
+		pr.Inputs[i], err = hex.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if r.Attestation != nil {
+		pr.Attestation = &pb.Attestation{
+			MobileOs:    r.Attestation.MobileOS,
+			SignedNonce: r.Attestation.SignedNonce,
+			Certificate: r.Attestation.Certificate,
+		}
+	}
+	return pr, nil
 }
 
 type BlindingResponse struct {
 	Outputs []string
+	Tags    []string `json:",omitempty"`
+}
+
+func (b *Blinder) blind(r *pb.BlindRequest, remoteAddr string) (resp *pb.BlindResponse, err error) {
+	if b.MaxInputs > 0 && len(r.Inputs) > b.MaxInputs {
+		return nil, ErrTooManyInputs.withMessage("too many inputs: %d>%d", len(r.Inputs), b.MaxInputs)
+	}
+
+	if b.Verifier == nil {
+		return nil, ErrNotAttested
+	}
+	var att *Attestation
+	if r.Attestation != nil {
+		att = &Attestation{
+			MobileOS:    r.Attestation.MobileOs,
+			SignedNonce: r.Attestation.SignedNonce,
+			Certificate: r.Attestation.Certificate,
+		}
+	}
+	digest := ProtoDigest(r)
+	clientID, err := b.Verifier.Verify(digest, att)
+	if err != nil {
+		if err == ErrNotAttested {
+			return nil, err
+		}
+		log.Printf("attestation verification failed: %s", err.Error())
+		return nil, ErrAttestationFailed
+	}
+	if att != nil {
+		if err := b.checkFresh(digest, r.Timestamp); err != nil {
+			return nil, err
+		}
+		// only served requests count as used, so that clients can retry
+		// after errors
+		defer func() {
+			if err != nil {
+				b.replays.forget(digest)
+			}
+		}()
+	}
+	if b.Limiter != nil {
+		if clientID == "" {
+			clientID, _, _ = net.SplitHostPort(remoteAddr)
+		}
+		if !b.Limiter.Allow(clientID) {
+			return nil, ErrRateLimited
+		}
+	}
+
+	if len(r.Tags) > 0 {
+		if !r.Ordered {
+			return nil, ErrInvalidTags.withMessage("tags are only allowed for ordered requests")
+		}
+		if len(r.Tags) != len(r.Inputs) {
+			return nil, ErrInvalidTags.withMessage("got %d tags for %d inputs", len(r.Tags), len(r.Inputs))
+		}
+	}
+
+	key, err := b.KeyForDay(int(r.DayId))
+	if err != nil {
+		if _, ok := err.(*Error); ok {
+			return nil, err
+		}
+		if os.IsNotExist(err) {
+			return nil, ErrUnknownDay
+		}
+		log.Printf("cannot load key for day %d: %s", r.DayId, err.Error())
+		return nil, ErrInternal
+	}
+
+	tokens := append([][]byte(nil), r.Inputs...)
+	blind := key.Blind
+	if r.Ordered {
+		blind = key.BlindInOrder
+	}
+	if err := blind(tokens); err != nil {
+		return nil, err
+	}
+	return &pb.BlindResponse{Outputs: tokens, Tags: r.Tags}, nil
+}
+
+// checkFresh rejects attested requests with a timestamp outside of the
+// window, and requests that were already served, so that captured requests
+// cannot be replayed.
+func (b *Blinder) checkFresh(digest []byte, timestamp int64) error {
+	window := b.AttestationWindow
+	if window == 0 {
+		window = DefaultAttestationWindow
+	}
+	now := time.Now()
+	t := time.Unix(timestamp, 0)
+	if t.Before(now.Add(-window)) || t.After(now.Add(window)) {
+		return ErrStaleAttestation.withMessage("request timestamp is more than %s away", window)
+	}
+	if !b.replays.check(digest, t.Add(window), now) {
+		return ErrStaleAttestation.withMessage("request was already served")
+	}
+	return nil
 }
 
 func (b *Blinder) actualServeHTTP(rw http.ResponseWriter, req *http.Request) error {
 	if req.Method != http.MethodPost {
-		return errors.New("Only POST allowed")
+		rw.Header().Set("Allow", http.MethodPost)
+		return ErrMethodNotAllowed
+	}
+	switch req.Header.Get("Content-Type") {
+	case "application/x-protobuf":
+		return b.protoServeHTTP(rw, req)
+	default:
+		return b.jsonServeHTTP(rw, req)
 	}
+}
 
-	// TODO: first hash the whole request and compare with signature in authorization
-	var r BlindingRequest
-	if err := json.NewDecoder(req.Body).Decode(&r); err != nil {
-		return err
+// Requests may be at most maxInputSize bytes per input (a hex encoded curve
+// point and its tag) plus requestOverhead, or defaultMaxRequestSize if
+// MaxInputs is not set, so that the caps cannot be bypassed with a single
+// huge request.
+const (
+	maxInputSize          = 256
+	requestOverhead       = 4096
+	defaultMaxRequestSize = 16 << 20
+)
+
+func (b *Blinder) maxRequestSize() int {
+	if b.MaxInputs <= 0 {
+		return defaultMaxRequestSize
 	}
+	return b.MaxInputs*maxInputSize + requestOverhead
+}
 
-	tokens := make([][]byte, len(r.Inputs))
-	for i, s := range r.Inputs {
-		var err error
-		tokens[i], err = hex.DecodeString(s)
-		if err != nil {
-			return err
+// readBody reads the body of req, up to maxRequestSize.
+func (b *Blinder) readBody(rw http.ResponseWriter, req *http.Request) ([]byte, error) {
+	limit := b.maxRequestSize()
+	contents, err := ioutil.ReadAll(http.MaxBytesReader(rw, req.Body, int64(limit)))
+	if err != nil {
+		// MaxBytesReader fails once the limit is read, other errors come
+		// from the connection
+		if len(contents) == limit {
+			return nil, ErrRequestTooLarge.withMessage("request is larger than %d bytes", limit)
 		}
+		return nil, ErrMalformedRequest.withMessage("cannot read request: %s", err.Error())
 	}
+	return contents, nil
+}
 
-	key, err := b.KeyForDay(r.DayID)
+func (b *Blinder) jsonServeHTTP(rw http.ResponseWriter, req *http.Request) error {
+	contents, err := b.readBody(rw, req)
 	if err != nil {
 		return err
 	}
+	var r BlindingRequest
+	if err := json.Unmarshal(contents, &r); err != nil {
+		return ErrMalformedRequest.withMessage("cannot parse request: %s", err.Error())
+	}
+	pr, err := r.toProto()
+	if err != nil {
+		return ErrMalformedRequest.withMessage("invalid input: %s", err.Error())
+	}
 
-	if err := key.Blind(tokens); err != nil {
+	presp, err := b.blind(pr, req.RemoteAddr)
+	if err != nil {
 		return err
 	}
 
-	resp := &BlindingResponse{Outputs: make([]string, len(tokens))}
-
-	for i, t := range tokens {
+	resp := &BlindingResponse{Outputs: make([]string, len(presp.Outputs)), Tags: presp.Tags}
+	for i, t := range presp.Outputs {
 		resp.Outputs[i] = hex.EncodeToString(t)
 	}
 
@@ -158,18 +406,48 @@ func (b *Blinder) actualServeHTTP(rw http.ResponseWriter, req *http.Request) err
 	return nil
 }
 
+func (b *Blinder) protoServeHTTP(rw http.ResponseWriter, req *http.Request) error {
+	contents, err := b.readBody(rw, req)
+	if err != nil {
+		return err
+	}
+	r := &pb.BlindRequest{}
+	if err := proto.Unmarshal(contents, r); err != nil {
+		return ErrMalformedRequest.withMessage("cannot parse request: %s", err.Error())
+	}
+
+	resp, err := b.blind(r, req.RemoteAddr)
+	if err != nil {
+		return err
+	}
+
+	response, err := proto.Marshal(resp)
+	if err != nil {
+		return err
+	}
+
+	rw.Header().Set("Content-Type", "application/x-protobuf")
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(response)
+	return nil
+}
+
 func (b *Blinder) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	err := b.actualServeHTTP(rw, req)
 	if err != nil {
 		log.Print("Request error: ", err)
-		http.Error(rw, err.Error(), http.StatusBadRequest)
+		e, ok := err.(*Error)
+		if !ok {
+			e = ErrInternal
+		}
+		writeError(rw, e)
 	}
 }
 
-func New(keyReader func(int) (*BlindingKey, error)) *Blinder {
+func New(keySource KeySource) *Blinder {
 	return &Blinder{
-		keyReader: keyReader,
-		keys:      make(map[int]*BlindingKey),
+		keySource: keySource,
+		keys:      make(map[int]*keyEntry),
 	}
 }
 
@@ -183,19 +461,6 @@ func (b *Blinder) Run(listenAddr string) error {
 	return s.ListenAndServe()
 }
 
-type KeyReader struct {
-	Dir string
-}
-
-func (kr KeyReader) ReadKey(dayID int) (*BlindingKey, error) {
-	path := filepath.Join(kr.Dir, fmt.Sprintf("%d.key", dayID))
-	rawKey, err := ioutil.ReadFile(path)
-	if err != nil {
-		return nil, err
-	}
-	return NewBlindingKey(string(rawKey)), nil
-}
-
 func init() {
 	if C.sodium_init() < 0 {
 		panic("sodium_init")
@@ -0,0 +1,271 @@
+package blinding
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/yunwilliamyu/contact-trace-mixnet/blinding/pb"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testKeys derives the keys of all days from the same master key.
+type testKeys struct{}
+
+func (testKeys) ReadKey(dayID int) (*BlindingKey, error) {
+	return NewBlindingKey("test"), nil
+}
+
+// testInputs are valid curve points.
+var testInputs = []string{
+	"203c1d0461b82aec4f5c9a71cd5c07047a11a65f7f972ee1ca532a3a32a30152",
+	"1e9736a142ac1bfc423f57199e60ec644eea5e44be0d92efa6643d2aad7d3f2f",
+	"e2f2ae0a6abc4e71a884a961c500515f58e30b6aa582dd8db6a65945e08d2d76",
+}
+
+// postJSON serves r as a JSON request, and decodes the response into resp if
+// it succeeded.
+func postJSON(t *testing.T, b *Blinder, r *BlindingRequest, resp *BlindingResponse) int {
+	t.Helper()
+	body, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/v0/blind", bytes.NewReader(body))
+	rw := httptest.NewRecorder()
+	b.ServeHTTP(rw, req)
+	if rw.Code == http.StatusOK {
+		if err := json.Unmarshal(rw.Body.Bytes(), resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rw.Code
+}
+
+func TestOrdered(t *testing.T) {
+	b := New(testKeys{})
+	b.Verifier = NoAttestation{}
+	var values [][]byte
+	for _, in := range testInputs {
+		v, err := hex.DecodeString(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, v)
+	}
+	if err := NewBlindingKey("test").BlindInOrder(values); err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for _, v := range values {
+		want = append(want, hex.EncodeToString(v))
+	}
+	tags := []string{"alice", "bob", "carol"}
+
+	var resp BlindingResponse
+	if code := postJSON(t, b, &BlindingRequest{DayID: 1, Inputs: testInputs, Ordered: true, Tags: tags}, &resp); code != http.StatusOK {
+		t.Fatalf("ordered request: got status %d", code)
+	}
+	if fmt.Sprint(resp.Outputs) != fmt.Sprint(want) {
+		t.Errorf("got outputs %v, want %v in the order of the inputs", resp.Outputs, want)
+	}
+	if fmt.Sprint(resp.Tags) != fmt.Sprint(tags) {
+		t.Errorf("got tags %v, want %v", resp.Tags, tags)
+	}
+
+	// unordered requests get the same outputs in any order, without tags
+	resp = BlindingResponse{}
+	if code := postJSON(t, b, &BlindingRequest{DayID: 1, Inputs: testInputs}, &resp); code != http.StatusOK {
+		t.Fatalf("unordered request: got status %d", code)
+	}
+	sort.Strings(resp.Outputs)
+	sort.Strings(want)
+	if fmt.Sprint(resp.Outputs) != fmt.Sprint(want) || resp.Tags != nil {
+		t.Errorf("got outputs %v and tags %v, want %v without tags", resp.Outputs, resp.Tags, want)
+	}
+
+	for _, r := range []*BlindingRequest{
+		{DayID: 1, Inputs: testInputs, Tags: tags},
+		{DayID: 1, Inputs: testInputs, Ordered: true, Tags: tags[:2]},
+	} {
+		if code := postJSON(t, b, r, &resp); code != http.StatusBadRequest {
+			t.Errorf("tags %v for ordered=%v: got status %d, want %d", r.Tags, r.Ordered, code, http.StatusBadRequest)
+		}
+	}
+}
+
+// blockingKeys counts key loads, and blocks loading day 1 until release is
+// closed. Loads fail while fail is set.
+type blockingKeys struct {
+	release chan struct{}
+	loads   map[int]int
+	fail    bool
+	mu      sync.Mutex
+}
+
+func (bk *blockingKeys) ReadKey(dayID int) (*BlindingKey, error) {
+	bk.mu.Lock()
+	bk.loads[dayID]++
+	fail := bk.fail
+	bk.mu.Unlock()
+	if dayID == 1 {
+		<-bk.release
+	}
+	if fail {
+		return nil, errors.New("key store unavailable")
+	}
+	return NewBlindingKey("test"), nil
+}
+
+func TestKeyForDaySingleFlight(t *testing.T) {
+	bk := &blockingKeys{release: make(chan struct{}), loads: make(map[int]int)}
+	b := New(bk)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := b.KeyForDay(1); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	// other days are not blocked by the pending load
+	for loading := false; !loading; {
+		time.Sleep(time.Millisecond)
+		bk.mu.Lock()
+		loading = bk.loads[1] > 0
+		bk.mu.Unlock()
+	}
+	if _, err := b.KeyForDay(2); err != nil {
+		t.Fatal(err)
+	}
+	close(bk.release)
+	wg.Wait()
+	if _, err := b.KeyForDay(1); err != nil {
+		t.Fatal(err)
+	}
+	if bk.loads[1] != 1 || bk.loads[2] != 1 {
+		t.Errorf("loaded keys %v times, want once per day", bk.loads)
+	}
+}
+
+func TestKeyForDayFailure(t *testing.T) {
+	bk := &blockingKeys{release: make(chan struct{}), loads: make(map[int]int), fail: true}
+	b := New(bk)
+	for i := 0; i < 2; i++ {
+		if _, err := b.KeyForDay(2); err == nil {
+			t.Fatal("KeyForDay did not return the error of the key source")
+		}
+	}
+	if bk.loads[2] != 1 {
+		t.Errorf("loaded key %d times, want the failure to be cached", bk.loads[2])
+	}
+	// the failure is forgotten after a while
+	bk.fail = false
+	b.keys[2].loadedAt = b.keys[2].loadedAt.Add(-failedKeyCacheTime)
+	if _, err := b.KeyForDay(2); err != nil {
+		t.Errorf("KeyForDay after the failure expired: %s", err.Error())
+	}
+	if bk.loads[2] != 2 {
+		t.Errorf("loaded key %d times, want 2", bk.loads[2])
+	}
+}
+
+func TestContentNegotiation(t *testing.T) {
+	b := New(testKeys{})
+	b.Verifier = NoAttestation{}
+	r := &BlindingRequest{DayID: 1, Inputs: testInputs, Ordered: true, Tags: []string{"a", "b", "c"}}
+	var want BlindingResponse
+	if code := postJSON(t, b, r, &want); code != http.StatusOK {
+		t.Fatalf("JSON request: got status %d", code)
+	}
+
+	pr, err := r.toProto()
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := proto.Marshal(pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/v0/blind", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	rw := httptest.NewRecorder()
+	b.ServeHTTP(rw, req)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("protobuf request: got status %d", rw.Code)
+	}
+	if ct := rw.Header().Get("Content-Type"); ct != "application/x-protobuf" {
+		t.Errorf("protobuf request: got Content-Type %q", ct)
+	}
+	resp := &pb.BlindResponse{}
+	if err := proto.Unmarshal(rw.Body.Bytes(), resp); err != nil {
+		t.Fatal(err)
+	}
+	for i, out := range resp.Outputs {
+		if hex.EncodeToString(out) != want.Outputs[i] {
+			t.Errorf("protobuf output %d differs from the JSON one", i)
+		}
+	}
+	if fmt.Sprint(resp.Tags) != fmt.Sprint(want.Tags) {
+		t.Errorf("got tags %v, want %v", resp.Tags, want.Tags)
+	}
+
+	// the body is parsed as announced
+	req = httptest.NewRequest(http.MethodPost, "/v0/blind", bytes.NewReader(body))
+	rw = httptest.NewRecorder()
+	b.ServeHTTP(rw, req)
+	if rw.Code != http.StatusBadRequest {
+		t.Errorf("protobuf request without Content-Type: got status %d, want %d", rw.Code, http.StatusBadRequest)
+	}
+}
+
+func TestRequestTooLarge(t *testing.T) {
+	b := New(missingKeys{})
+	b.Verifier = NoAttestation{}
+	b.MaxInputs = 2
+	for _, contentType := range []string{"application/json", "application/x-protobuf"} {
+		body := bytes.Repeat([]byte{' '}, b.maxRequestSize()+1)
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		rw := httptest.NewRecorder()
+		b.ServeHTTP(rw, req)
+		if rw.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s: got status %d, want %d", contentType, rw.Code, http.StatusRequestEntityTooLarge)
+		}
+	}
+}
+
+// errReader fails after returning its contents.
+type errReader struct {
+	contents []byte
+}
+
+func (er *errReader) Read(p []byte) (int, error) {
+	if len(er.contents) == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n := copy(p, er.contents)
+	er.contents = er.contents[n:]
+	return n, nil
+}
+
+func TestBrokenRequest(t *testing.T) {
+	b := New(missingKeys{})
+	b.Verifier = NoAttestation{}
+	req := httptest.NewRequest(http.MethodPost, "/", &errReader{contents: []byte("{")})
+	rw := httptest.NewRecorder()
+	b.ServeHTTP(rw, req)
+	if rw.Code != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d", rw.Code, http.StatusBadRequest)
+	}
+}
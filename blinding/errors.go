@@ -0,0 +1,53 @@
+package blinding
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Error is an error that is reported to clients. Code is stable and meant to
+// be machine-readable, while Message is for humans.
+type Error struct {
+	Code    string
+	Message string
+	Status  int `json:"-"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// withMessage returns a copy of e with a more specific message.
+func (e *Error) withMessage(format string, args ...interface{}) *Error {
+	return &Error{Code: e.Code, Message: fmt.Sprintf(format, args...), Status: e.Status}
+}
+
+var (
+	ErrMethodNotAllowed  = &Error{Code: "method_not_allowed", Message: "only POST allowed", Status: http.StatusMethodNotAllowed}
+	ErrMalformedRequest  = &Error{Code: "malformed_request", Message: "cannot parse request", Status: http.StatusBadRequest}
+	ErrTooManyInputs     = &Error{Code: "too_many_inputs", Message: "too many inputs", Status: http.StatusBadRequest}
+	ErrRequestTooLarge   = &Error{Code: "request_too_large", Message: "request is too large", Status: http.StatusRequestEntityTooLarge}
+	ErrInvalidTags       = &Error{Code: "invalid_tags", Message: "invalid tags", Status: http.StatusBadRequest}
+	ErrInvalidLength     = &Error{Code: "invalid_length", Message: "invalid length of curve point", Status: http.StatusBadRequest}
+	ErrNotOnCurve        = &Error{Code: "not_on_curve", Message: "point not on curve", Status: http.StatusBadRequest}
+	ErrDuplicateInputs   = &Error{Code: "duplicate_inputs", Message: "inputs are not distinct", Status: http.StatusBadRequest}
+	ErrNotAttested       = &Error{Code: "not_attested", Message: "request is not attested", Status: http.StatusForbidden}
+	ErrAttestationFailed = &Error{Code: "attestation_failed", Message: "attestation verification failed", Status: http.StatusForbidden}
+	ErrStaleAttestation  = &Error{Code: "stale_attestation", Message: "attestation is too old or reused", Status: http.StatusForbidden}
+	ErrRateLimited       = &Error{Code: "rate_limited", Message: "too many requests", Status: http.StatusTooManyRequests}
+	ErrDayOutOfWindow    = &Error{Code: "day_not_served", Message: "day is outside of the served window", Status: http.StatusNotFound}
+	ErrUnknownDay        = &Error{Code: "unknown_day", Message: "no key for this day", Status: http.StatusNotFound}
+	ErrInternal          = &Error{Code: "internal", Message: "internal error", Status: http.StatusInternalServerError}
+)
+
+func writeError(rw http.ResponseWriter, e *Error) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		http.Error(rw, e.Message, e.Status)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(e.Status)
+	rw.Write(body)
+}
@@ -0,0 +1,118 @@
+package blinding
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGRPCCode(t *testing.T) {
+	for _, tc := range []struct {
+		err  *Error
+		want codes.Code
+	}{
+		{ErrMalformedRequest, codes.InvalidArgument},
+		{ErrTooManyInputs, codes.InvalidArgument},
+		{ErrRequestTooLarge, codes.ResourceExhausted},
+		{ErrInvalidTags, codes.InvalidArgument},
+		{ErrInvalidLength, codes.InvalidArgument},
+		{ErrNotOnCurve, codes.InvalidArgument},
+		{ErrDuplicateInputs, codes.InvalidArgument},
+		{ErrNotAttested, codes.PermissionDenied},
+		{ErrAttestationFailed, codes.PermissionDenied},
+		{ErrStaleAttestation, codes.PermissionDenied},
+		{ErrRateLimited, codes.ResourceExhausted},
+		{ErrDayOutOfWindow, codes.NotFound},
+		{ErrUnknownDay, codes.NotFound},
+		{ErrInternal, codes.Internal},
+	} {
+		if got := grpcCode(tc.err.Status); got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.err.Code, got, tc.want)
+		}
+	}
+}
+
+// failingKeys cannot read any key.
+type failingKeys struct{}
+
+func (failingKeys) ReadKey(dayID int) (*BlindingKey, error) {
+	return nil, errors.New("disk on fire")
+}
+
+func TestErrorResponses(t *testing.T) {
+	attested := func(b *Blinder) { b.Verifier = NoAttestation{} }
+	for _, tc := range []struct {
+		name  string
+		setup func(b *Blinder)
+		keys  KeySource
+		r     *BlindingRequest
+		want  *Error
+	}{
+		{"too many inputs", func(b *Blinder) { attested(b); b.MaxInputs = 2 }, testKeys{},
+			&BlindingRequest{DayID: 1, Inputs: testInputs}, ErrTooManyInputs},
+		{"invalid tags", attested, testKeys{},
+			&BlindingRequest{DayID: 1, Inputs: testInputs, Tags: []string{"a", "b", "c"}}, ErrInvalidTags},
+		{"invalid length", attested, testKeys{},
+			&BlindingRequest{DayID: 1, Inputs: []string{"00"}}, ErrInvalidLength},
+		{"duplicate inputs", attested, testKeys{},
+			&BlindingRequest{DayID: 1, Inputs: []string{testInputs[0], testInputs[0]}}, ErrDuplicateInputs},
+		{"not attested", func(b *Blinder) {}, testKeys{},
+			&BlindingRequest{DayID: 1, Inputs: testInputs}, ErrNotAttested},
+		{"rate limited", func(b *Blinder) { attested(b); b.Limiter = NewRateLimiter(24, 0) }, testKeys{},
+			&BlindingRequest{DayID: 1, Inputs: testInputs}, ErrRateLimited},
+		{"internal", attested, failingKeys{},
+			&BlindingRequest{DayID: 1, Inputs: testInputs}, ErrInternal},
+	} {
+		b := New(tc.keys)
+		tc.setup(b)
+
+		body, err := json.Marshal(tc.r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/v0/blind", bytes.NewReader(body))
+		rw := httptest.NewRecorder()
+		b.ServeHTTP(rw, req)
+		if rw.Code != tc.want.Status {
+			t.Errorf("%s: got status %d, want %d", tc.name, rw.Code, tc.want.Status)
+		}
+		if ct := rw.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s: got Content-Type %q", tc.name, ct)
+		}
+		var e Error
+		if err := json.Unmarshal(rw.Body.Bytes(), &e); err != nil {
+			t.Errorf("%s: cannot parse error: %s", tc.name, err.Error())
+		} else if e.Code != tc.want.Code || e.Message == "" {
+			t.Errorf("%s: got error %+v, want code %q", tc.name, e, tc.want.Code)
+		}
+
+		pr, err := tc.r.toProto()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b = New(tc.keys)
+		tc.setup(b)
+		_, err = (&grpcBlinder{b: b}).Blind(context.Background(), pr)
+		if got, want := status.Code(err), grpcCode(tc.want.Status); got != want {
+			t.Errorf("%s: got gRPC code %s, want %s", tc.name, got, want)
+		}
+	}
+
+	b := New(testKeys{})
+	rw := httptest.NewRecorder()
+	b.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/v0/blind", nil))
+	if rw.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: got status %d, want %d", rw.Code, http.StatusMethodNotAllowed)
+	}
+	rw = httptest.NewRecorder()
+	b.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/v0/blind", bytes.NewReader([]byte("{"))))
+	if rw.Code != http.StatusBadRequest || !bytes.Contains(rw.Body.Bytes(), []byte(ErrMalformedRequest.Code)) {
+		t.Errorf("malformed request: got status %d and %q", rw.Code, rw.Body.String())
+	}
+}
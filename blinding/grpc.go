@@ -0,0 +1,62 @@
+package blinding
+
+import (
+	"context"
+	"github.com/yunwilliamyu/contact-trace-mixnet/blinding/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"net/http"
+)
+
+//go:generate protoc pb/blinding.proto --go_out=plugins=grpc:.
+
+type grpcBlinder struct {
+	pb.UnimplementedBlinderServer
+
+	b *Blinder
+}
+
+func (gb *grpcBlinder) Blind(ctx context.Context, req *pb.BlindRequest) (*pb.BlindResponse, error) {
+	var remoteAddr string
+	if p, ok := peer.FromContext(ctx); ok {
+		remoteAddr = p.Addr.String()
+	}
+	resp, err := gb.b.blind(req, remoteAddr)
+	if err != nil {
+		e, ok := err.(*Error)
+		if !ok {
+			e = ErrInternal
+		}
+		return nil, status.Errorf(grpcCode(e.Status), "%s: %s", e.Code, e.Message)
+	}
+	return resp, nil
+}
+
+func grpcCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusTooManyRequests, http.StatusRequestEntityTooLarge:
+		return codes.ResourceExhausted
+	default:
+		return codes.Internal
+	}
+}
+
+// RunGRPC serves the Blinder gRPC service.
+func (b *Blinder) RunGRPC(listenAddr string) error {
+	lis, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return err
+	}
+	s := grpc.NewServer(grpc.MaxRecvMsgSize(b.maxRequestSize()))
+	pb.RegisterBlinderServer(s, &grpcBlinder{b: b})
+	return s.Serve(lis)
+}
@@ -0,0 +1,61 @@
+package blinding
+
+import (
+	"context"
+	"encoding/hex"
+	"github.com/yunwilliamyu/contact-trace-mixnet/blinding/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestGRPC(t *testing.T) {
+	b := New(testKeys{})
+	b.Verifier = NoAttestation{}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	pb.RegisterBlinderServer(s, &grpcBlinder{b: b})
+	go s.Serve(lis)
+	defer s.Stop()
+
+	cc, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+	client := pb.NewBlinderClient(cc)
+
+	r := &BlindingRequest{DayID: 1, Inputs: testInputs, Ordered: true}
+	var want BlindingResponse
+	if code := postJSON(t, b, r, &want); code != http.StatusOK {
+		t.Fatalf("JSON request: got status %d", code)
+	}
+	pr, err := r.toProto()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Blind(context.Background(), pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Outputs) != len(want.Outputs) {
+		t.Fatalf("got %d outputs, want %d", len(resp.Outputs), len(want.Outputs))
+	}
+	for i, out := range resp.Outputs {
+		if hex.EncodeToString(out) != want.Outputs[i] {
+			t.Errorf("gRPC output %d differs from the JSON one", i)
+		}
+	}
+
+	pr.Ordered = false
+	pr.Tags = []string{"a", "b", "c"}
+	if _, err := client.Blind(context.Background(), pr); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("request with tags: got %v, want %s", err, codes.InvalidArgument)
+	}
+}
@@ -0,0 +1,191 @@
+package blinding
+
+import (
+	cryptorand "crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const masterKeySize = 64
+
+// DayID returns the day number (days since the Unix epoch, UTC) of t.
+func DayID(t time.Time) int {
+	return int(t.Unix() / (24 * 60 * 60))
+}
+
+// KeyManager manages the on-disk lifecycle of day keys stored as
+// <Dir>/<dayID>.key: it generates them ahead of time, refuses to serve days
+// outside of a window around today, and erases them after a retention period.
+// If Dir is empty, only the window is enforced.
+type KeyManager struct {
+	Dir string
+	// Source is where keys are read from. Defaults to KeyReader{Dir}.
+	Source KeySource
+	// Seal, if set, is applied to generated keys before they are written,
+	// e.g. SealedKeyReader.Seal.
+	Seal func(masterKey []byte) ([]byte, error)
+	// Days older than today-MaxPastDays or newer than today+MaxFutureDays
+	// are refused.
+	MaxPastDays   int
+	MaxFutureDays int
+	// Keys of days older than today-RetentionDays are deleted. It should
+	// not be less than MaxPastDays.
+	RetentionDays int
+
+	Now func() time.Time // defaults to time.Now
+}
+
+func (km *KeyManager) Today() int {
+	if km.Now != nil {
+		return DayID(km.Now())
+	}
+	return DayID(time.Now())
+}
+
+func (km *KeyManager) CheckDay(dayID int) error {
+	today := km.Today()
+	if dayID < today-km.MaxPastDays || dayID > today+km.MaxFutureDays {
+		return ErrDayOutOfWindow
+	}
+	return nil
+}
+
+func (km *KeyManager) keyPath(dayID int) string {
+	return filepath.Join(km.Dir, fmt.Sprintf("%d.key", dayID))
+}
+
+func (km *KeyManager) ReadKey(dayID int) (*BlindingKey, error) {
+	if err := km.CheckDay(dayID); err != nil {
+		return nil, err
+	}
+	if km.Source != nil {
+		return km.Source.ReadKey(dayID)
+	}
+	return KeyReader{Dir: km.Dir}.ReadKey(dayID)
+}
+
+// Generate creates keys for days [from, to]. Existing keys are left alone,
+// so that it is safe to run it repeatedly.
+func (km *KeyManager) Generate(from, to int) error {
+	for dayID := from; dayID <= to; dayID++ {
+		created, err := km.generateKey(dayID)
+		if err != nil {
+			return err
+		}
+		if created {
+			log.Printf("generated key for day %d", dayID)
+		}
+	}
+	return nil
+}
+
+// GenerateUpcoming creates all keys that may be served from now on.
+func (km *KeyManager) GenerateUpcoming() error {
+	today := km.Today()
+	return km.Generate(today, today+km.MaxFutureDays)
+}
+
+func (km *KeyManager) generateKey(dayID int) (bool, error) {
+	var key [masterKeySize]byte
+	if _, err := io.ReadFull(cryptorand.Reader, key[:]); err != nil {
+		return false, err
+	}
+	contents := key[:]
+	if km.Seal != nil {
+		var err error
+		if contents, err = km.Seal(key[:]); err != nil {
+			return false, err
+		}
+	}
+
+	f, err := os.OpenFile(km.keyPath(dayID), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		if os.IsExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if _, err := f.Write(contents); err != nil {
+		f.Close()
+		return false, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return false, err
+	}
+	return true, f.Close()
+}
+
+// Expire erases all keys older than the retention period and returns the
+// first day that is still retained.
+func (km *KeyManager) Expire() (int, error) {
+	cutoff := km.Today() - km.RetentionDays
+	if km.Dir == "" {
+		return cutoff, nil
+	}
+	infos, err := ioutil.ReadDir(km.Dir)
+	if err != nil {
+		return cutoff, err
+	}
+	for _, info := range infos {
+		name := info.Name()
+		if !strings.HasSuffix(name, ".key") || info.IsDir() {
+			continue
+		}
+		dayID, err := strconv.Atoi(strings.TrimSuffix(name, ".key"))
+		if err != nil || dayID >= cutoff {
+			continue
+		}
+		if err := eraseFile(filepath.Join(km.Dir, name), info.Size()); err != nil {
+			return cutoff, err
+		}
+		log.Printf("erased key for day %d", dayID)
+	}
+	return cutoff, nil
+}
+
+// eraseFile overwrites the file with zeroes before removing it. This is
+// best-effort: journaling filesystems and SSDs may keep old copies around.
+func eraseFile(path string, size int64) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(make([]byte, size)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Maintain periodically erases expired keys, evicting them from the
+// Blinder's cache as well, and, if generate is set, creates upcoming keys.
+func (km *KeyManager) Maintain(b *Blinder, interval time.Duration, generate bool) {
+	for {
+		if generate {
+			if err := km.GenerateUpcoming(); err != nil {
+				log.Printf("error while generating keys: %s", err.Error())
+			}
+		}
+		cutoff, err := km.Expire()
+		if err != nil {
+			log.Printf("error while expiring keys: %s", err.Error())
+		}
+		b.EvictBefore(cutoff)
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,84 @@
+package blinding
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKeyManagerWindow(t *testing.T) {
+	now := time.Unix(100*24*60*60+3600, 0)
+	km := &KeyManager{MaxPastDays: 2, MaxFutureDays: 1, Now: func() time.Time { return now }}
+	if today := km.Today(); today != 100 {
+		t.Fatalf("Today is %d, want 100", today)
+	}
+	for _, tc := range []struct {
+		dayID int
+		ok    bool
+	}{
+		{97, false},
+		{98, true},
+		{100, true},
+		{101, true},
+		{102, false},
+	} {
+		err := km.CheckDay(tc.dayID)
+		if tc.ok && err != nil {
+			t.Errorf("day %d: %s", tc.dayID, err.Error())
+		}
+		if !tc.ok && err != ErrDayOutOfWindow {
+			t.Errorf("day %d: got %v, want %v", tc.dayID, err, ErrDayOutOfWindow)
+		}
+	}
+}
+
+func TestKeyManagerLifecycle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	now := time.Unix(100*24*60*60, 0)
+	km := &KeyManager{Dir: dir, MaxPastDays: 1, MaxFutureDays: 2, RetentionDays: 3, Now: func() time.Time { return now }}
+
+	if err := km.Generate(95, 99); err != nil {
+		t.Fatal(err)
+	}
+	if err := km.GenerateUpcoming(); err != nil {
+		t.Fatal(err)
+	}
+	// existing keys are kept
+	key, err := ioutil.ReadFile(km.keyPath(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := km.GenerateUpcoming(); err != nil {
+		t.Fatal(err)
+	}
+	if again, err := ioutil.ReadFile(km.keyPath(100)); err != nil || string(again) != string(key) {
+		t.Errorf("GenerateUpcoming replaced an existing key")
+	}
+	if _, err := km.ReadKey(100); err != nil {
+		t.Errorf("ReadKey: %s", err.Error())
+	}
+	if _, err := km.ReadKey(98); err != ErrDayOutOfWindow {
+		t.Errorf("ReadKey of a key outside of the window: got %v, want %v", err, ErrDayOutOfWindow)
+	}
+
+	cutoff, err := km.Expire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cutoff != 97 {
+		t.Errorf("Expire returned %d, want 97", cutoff)
+	}
+	for dayID := 95; dayID <= 102; dayID++ {
+		_, err := os.Stat(filepath.Join(dir, fmt.Sprintf("%d.key", dayID)))
+		if exists := err == nil; exists != (dayID >= 97) {
+			t.Errorf("key of day %d exists: %v", dayID, exists)
+		}
+	}
+}
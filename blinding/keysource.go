@@ -0,0 +1,180 @@
+package blinding
+
+import (
+	"crypto/hmac"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// KeySource provides the blinding key of a given day.
+type KeySource interface {
+	ReadKey(dayID int) (*BlindingKey, error)
+}
+
+// KeyReader reads plaintext master keys from <Dir>/<dayID>.key.
+type KeyReader struct {
+	Dir string
+}
+
+func (kr KeyReader) ReadKey(dayID int) (*BlindingKey, error) {
+	path := filepath.Join(kr.Dir, fmt.Sprintf("%d.key", dayID))
+	rawKey, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewBlindingKey(string(rawKey)), nil
+}
+
+const (
+	sealSaltSize  = 16
+	sealNonceSize = 24
+	sealHeader    = sealSaltSize + sealNonceSize
+)
+
+// SealedKeyReader reads master keys from <Dir>/<dayID>.key that are encrypted
+// at rest with NaCl secretbox. The files are salt || nonce || box. The box key
+// is either SecretKey, or, if that is nil, derived from Passphrase and the
+// salt with scrypt.
+type SealedKeyReader struct {
+	Dir        string
+	Passphrase []byte
+	SecretKey  *[32]byte
+}
+
+func (skr SealedKeyReader) boxKey(salt []byte) (*[32]byte, error) {
+	if skr.SecretKey != nil {
+		return skr.SecretKey, nil
+	}
+	if len(skr.Passphrase) == 0 {
+		return nil, errors.New("neither passphrase nor secret key provided")
+	}
+	derived, err := scrypt.Key(skr.Passphrase, salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	var k [32]byte
+	copy(k[:], derived)
+	return &k, nil
+}
+
+// Seal encrypts a master key in the format read by ReadKey.
+func (skr SealedKeyReader) Seal(masterKey []byte) ([]byte, error) {
+	var header [sealHeader]byte
+	if _, err := io.ReadFull(cryptorand.Reader, header[:]); err != nil {
+		return nil, err
+	}
+	k, err := skr.boxKey(header[:sealSaltSize])
+	if err != nil {
+		return nil, err
+	}
+	var nonce [sealNonceSize]byte
+	copy(nonce[:], header[sealSaltSize:])
+	return secretbox.Seal(header[:], masterKey, &nonce, k), nil
+}
+
+func (skr SealedKeyReader) ReadKey(dayID int) (*BlindingKey, error) {
+	path := filepath.Join(skr.Dir, fmt.Sprintf("%d.key", dayID))
+	sealed, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < sealHeader+secretbox.Overhead {
+		return nil, fmt.Errorf("sealed key for day %d is too short", dayID)
+	}
+	k, err := skr.boxKey(sealed[:sealSaltSize])
+	if err != nil {
+		return nil, err
+	}
+	var nonce [sealNonceSize]byte
+	copy(nonce[:], sealed[sealSaltSize:sealHeader])
+	rawKey, ok := secretbox.Open(nil, sealed[sealHeader:], &nonce, k)
+	if !ok {
+		return nil, fmt.Errorf("cannot unseal key for day %d", dayID)
+	}
+	return NewBlindingKey(string(rawKey)), nil
+}
+
+// PKCS11Token is the subset of a PKCS#11 token needed to derive blinding keys
+// without the master keys ever leaving the token.
+type PKCS11Token interface {
+	// FindKey returns a handle of the secret key object with the given
+	// CKA_LABEL (C_FindObjects).
+	FindKey(label string) (uint, error)
+	// SignHMAC computes CKM_SHA256_HMAC of data with the given key (C_Sign).
+	SignHMAC(key uint, data []byte) ([]byte, error)
+}
+
+// HSMKeySource derives the master key of a day as HMAC-SHA256 computed by
+// the token with the secret key labelled "blinding-<dayID>".
+type HSMKeySource struct {
+	Token PKCS11Token
+}
+
+func hsmKeyLabel(dayID int) string {
+	return fmt.Sprintf("blinding-%d", dayID)
+}
+
+func (hks HSMKeySource) ReadKey(dayID int) (*BlindingKey, error) {
+	handle, err := hks.Token.FindKey(hsmKeyLabel(dayID))
+	if err != nil {
+		return nil, err
+	}
+	rawKey, err := hks.Token.SignHMAC(handle, []byte("BLINDING_MASTER_KEY"))
+	if err != nil {
+		return nil, err
+	}
+	return NewBlindingKey(string(rawKey)), nil
+}
+
+// SoftToken is a software stand-in for a PKCS#11 token, keeping each secret
+// key object in <Dir>/<label>. It is meant for testing and development.
+type SoftToken struct {
+	Dir string
+
+	objects []string
+	mu      sync.Mutex
+}
+
+func (st *SoftToken) FindKey(label string) (uint, error) {
+	if filepath.Base(label) != label {
+		return 0, fmt.Errorf("invalid label %q", label)
+	}
+	if _, err := os.Stat(filepath.Join(st.Dir, label)); err != nil {
+		return 0, err
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for i, l := range st.objects {
+		if l == label {
+			return uint(i), nil
+		}
+	}
+	st.objects = append(st.objects, label)
+	return uint(len(st.objects) - 1), nil
+}
+
+func (st *SoftToken) SignHMAC(key uint, data []byte) ([]byte, error) {
+	st.mu.Lock()
+	if key >= uint(len(st.objects)) {
+		st.mu.Unlock()
+		return nil, errors.New("invalid object handle")
+	}
+	label := st.objects[key]
+	st.mu.Unlock()
+	secret, err := ioutil.ReadFile(filepath.Join(st.Dir, label))
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(data)
+	return mac.Sum(nil), nil
+}
@@ -0,0 +1,94 @@
+package blinding
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeySources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name string, contents []byte) {
+		t.Helper()
+		if err := ioutil.WriteFile(filepath.Join(dir, name), contents, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	masterKey := []byte("master key of day 1")
+
+	plain := KeyReader{Dir: filepath.Join(dir, "plain")}
+	secretKey := &[32]byte{1}
+	sealed := SealedKeyReader{Dir: filepath.Join(dir, "sealed"), SecretKey: secretKey}
+	passphrase := SealedKeyReader{Dir: filepath.Join(dir, "passphrase"), Passphrase: []byte("correct horse")}
+	for _, sub := range []string{"plain", "sealed", "passphrase", "token"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("plain/1.key", masterKey)
+	for _, skr := range []SealedKeyReader{sealed, passphrase} {
+		box, err := skr.Seal(masterKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		write(filepath.Join(filepath.Base(skr.Dir), "1.key"), box)
+	}
+	tokenSecret := []byte("token secret")
+	write("token/"+hsmKeyLabel(1), tokenSecret)
+	mac := hmac.New(sha256.New, tokenSecret)
+	mac.Write([]byte("BLINDING_MASTER_KEY"))
+	hsmMasterKey := mac.Sum(nil)
+
+	for _, tc := range []struct {
+		name   string
+		source KeySource
+		want   []byte // master key of day 1, nil if it cannot be read
+	}{
+		{"plain", plain, masterKey},
+		{"sealed", sealed, masterKey},
+		{"passphrase", passphrase, masterKey},
+		{"wrong secret key", SealedKeyReader{Dir: sealed.Dir, SecretKey: &[32]byte{2}}, nil},
+		{"wrong passphrase", SealedKeyReader{Dir: passphrase.Dir, Passphrase: []byte("wrong")}, nil},
+		{"no secret", SealedKeyReader{Dir: sealed.Dir}, nil},
+		{"plaintext key as sealed", SealedKeyReader{Dir: plain.Dir, SecretKey: secretKey}, nil},
+		{"softhsm", HSMKeySource{Token: &SoftToken{Dir: filepath.Join(dir, "token")}}, hsmMasterKey},
+	} {
+		key, err := tc.source.ReadKey(1)
+		if tc.want == nil {
+			if err == nil {
+				t.Errorf("%s: ReadKey did not fail", tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err.Error())
+			continue
+		}
+		if key.blindingKey != NewBlindingKey(string(tc.want)).blindingKey {
+			t.Errorf("%s: got the wrong key", tc.name)
+		}
+		// missing days
+		if _, err := tc.source.ReadKey(2); !os.IsNotExist(err) {
+			t.Errorf("%s: ReadKey of a missing day: got %v, want a not-exist error", tc.name, err)
+		}
+	}
+}
+
+func TestSoftTokenLabels(t *testing.T) {
+	st := &SoftToken{Dir: "testdata"}
+	for _, label := range []string{"../blinding-1", "a/b"} {
+		if _, err := st.FindKey(label); err == nil {
+			t.Errorf("FindKey accepted label %q", label)
+		}
+	}
+	if _, err := st.SignHMAC(0, nil); err == nil {
+		t.Errorf("SignHMAC accepted an unknown handle")
+	}
+}
@@ -0,0 +1,319 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: pb/blinding.proto
+
+package pb
+
+import (
+	context "context"
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+type Attestation struct {
+	MobileOs             string   `protobuf:"bytes,1,opt,name=mobile_os,json=mobileOs,proto3" json:"mobile_os,omitempty"`
+	SignedNonce          []byte   `protobuf:"bytes,2,opt,name=signed_nonce,json=signedNonce,proto3" json:"signed_nonce,omitempty"`
+	Certificate          []byte   `protobuf:"bytes,3,opt,name=certificate,proto3" json:"certificate,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Attestation) Reset()         { *m = Attestation{} }
+func (m *Attestation) String() string { return proto.CompactTextString(m) }
+func (*Attestation) ProtoMessage()    {}
+func (*Attestation) Descriptor() ([]byte, []int) {
+	return fileDescriptor_4fae994c4c1e69c4, []int{0}
+}
+
+func (m *Attestation) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Attestation.Unmarshal(m, b)
+}
+func (m *Attestation) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Attestation.Marshal(b, m, deterministic)
+}
+func (m *Attestation) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Attestation.Merge(m, src)
+}
+func (m *Attestation) XXX_Size() int {
+	return xxx_messageInfo_Attestation.Size(m)
+}
+func (m *Attestation) XXX_DiscardUnknown() {
+	xxx_messageInfo_Attestation.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Attestation proto.InternalMessageInfo
+
+func (m *Attestation) GetMobileOs() string {
+	if m != nil {
+		return m.MobileOs
+	}
+	return ""
+}
+
+func (m *Attestation) GetSignedNonce() []byte {
+	if m != nil {
+		return m.SignedNonce
+	}
+	return nil
+}
+
+func (m *Attestation) GetCertificate() []byte {
+	if m != nil {
+		return m.Certificate
+	}
+	return nil
+}
+
+type BlindRequest struct {
+	DayId int64 `protobuf:"varint,1,opt,name=day_id,json=dayId,proto3" json:"day_id,omitempty"`
+	// 32-byte curve points
+	Inputs      [][]byte     `protobuf:"bytes,2,rep,name=inputs,proto3" json:"inputs,omitempty"`
+	Ordered     bool         `protobuf:"varint,3,opt,name=ordered,proto3" json:"ordered,omitempty"`
+	Tags        []string     `protobuf:"bytes,4,rep,name=tags,proto3" json:"tags,omitempty"`
+	Attestation *Attestation `protobuf:"bytes,5,opt,name=attestation,proto3" json:"attestation,omitempty"`
+	// unix time in seconds at which the request was attested
+	Timestamp            int64    `protobuf:"varint,6,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *BlindRequest) Reset()         { *m = BlindRequest{} }
+func (m *BlindRequest) String() string { return proto.CompactTextString(m) }
+func (*BlindRequest) ProtoMessage()    {}
+func (*BlindRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_4fae994c4c1e69c4, []int{1}
+}
+
+func (m *BlindRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BlindRequest.Unmarshal(m, b)
+}
+func (m *BlindRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BlindRequest.Marshal(b, m, deterministic)
+}
+func (m *BlindRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BlindRequest.Merge(m, src)
+}
+func (m *BlindRequest) XXX_Size() int {
+	return xxx_messageInfo_BlindRequest.Size(m)
+}
+func (m *BlindRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_BlindRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BlindRequest proto.InternalMessageInfo
+
+func (m *BlindRequest) GetDayId() int64 {
+	if m != nil {
+		return m.DayId
+	}
+	return 0
+}
+
+func (m *BlindRequest) GetInputs() [][]byte {
+	if m != nil {
+		return m.Inputs
+	}
+	return nil
+}
+
+func (m *BlindRequest) GetOrdered() bool {
+	if m != nil {
+		return m.Ordered
+	}
+	return false
+}
+
+func (m *BlindRequest) GetTags() []string {
+	if m != nil {
+		return m.Tags
+	}
+	return nil
+}
+
+func (m *BlindRequest) GetAttestation() *Attestation {
+	if m != nil {
+		return m.Attestation
+	}
+	return nil
+}
+
+func (m *BlindRequest) GetTimestamp() int64 {
+	if m != nil {
+		return m.Timestamp
+	}
+	return 0
+}
+
+type BlindResponse struct {
+	Outputs              [][]byte `protobuf:"bytes,1,rep,name=outputs,proto3" json:"outputs,omitempty"`
+	Tags                 []string `protobuf:"bytes,2,rep,name=tags,proto3" json:"tags,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *BlindResponse) Reset()         { *m = BlindResponse{} }
+func (m *BlindResponse) String() string { return proto.CompactTextString(m) }
+func (*BlindResponse) ProtoMessage()    {}
+func (*BlindResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_4fae994c4c1e69c4, []int{2}
+}
+
+func (m *BlindResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BlindResponse.Unmarshal(m, b)
+}
+func (m *BlindResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BlindResponse.Marshal(b, m, deterministic)
+}
+func (m *BlindResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BlindResponse.Merge(m, src)
+}
+func (m *BlindResponse) XXX_Size() int {
+	return xxx_messageInfo_BlindResponse.Size(m)
+}
+func (m *BlindResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_BlindResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BlindResponse proto.InternalMessageInfo
+
+func (m *BlindResponse) GetOutputs() [][]byte {
+	if m != nil {
+		return m.Outputs
+	}
+	return nil
+}
+
+func (m *BlindResponse) GetTags() []string {
+	if m != nil {
+		return m.Tags
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterType((*Attestation)(nil), "pb.Attestation")
+	proto.RegisterType((*BlindRequest)(nil), "pb.BlindRequest")
+	proto.RegisterType((*BlindResponse)(nil), "pb.BlindResponse")
+}
+
+func init() { proto.RegisterFile("pb/blinding.proto", fileDescriptor_4fae994c4c1e69c4) }
+
+var fileDescriptor_4fae994c4c1e69c4 = []byte{
+	// 300 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x4c, 0x91, 0xb1, 0x4e, 0xf3, 0x30,
+	0x10, 0xc7, 0xbf, 0x24, 0x6d, 0xda, 0x5c, 0xf2, 0x09, 0x7a, 0x12, 0xc8, 0x02, 0x86, 0x90, 0x29,
+	0x53, 0x10, 0x65, 0x62, 0x60, 0x80, 0x8d, 0x05, 0x24, 0xbf, 0x40, 0x15, 0xd7, 0xa6, 0xb2, 0xd4,
+	0xda, 0x26, 0xbe, 0x0e, 0x7d, 0x37, 0x1e, 0x0e, 0xc5, 0x69, 0x69, 0xb6, 0xfb, 0xff, 0x74, 0xf6,
+	0xfd, 0xce, 0x86, 0x85, 0x13, 0x0f, 0x62, 0xab, 0x8d, 0xd4, 0x66, 0xd3, 0xb8, 0xce, 0x92, 0xc5,
+	0xd8, 0x89, 0xca, 0x42, 0xfe, 0x4a, 0xa4, 0x3c, 0xb5, 0xa4, 0xad, 0xc1, 0x5b, 0xc8, 0x76, 0x56,
+	0xe8, 0xad, 0x5a, 0x59, 0xcf, 0xa2, 0x32, 0xaa, 0x33, 0x3e, 0x1f, 0xc0, 0xa7, 0xc7, 0x7b, 0x28,
+	0xbc, 0xde, 0x18, 0x25, 0x57, 0xc6, 0x9a, 0xb5, 0x62, 0x71, 0x19, 0xd5, 0x05, 0xcf, 0x07, 0xf6,
+	0xd1, 0x23, 0x2c, 0x21, 0x5f, 0xab, 0x8e, 0xf4, 0x97, 0x5e, 0xb7, 0xa4, 0x58, 0x32, 0x74, 0x8c,
+	0x50, 0xf5, 0x13, 0x41, 0xf1, 0xd6, 0x7b, 0x70, 0xf5, 0xbd, 0x57, 0x9e, 0xf0, 0x0a, 0x52, 0xd9,
+	0x1e, 0x56, 0x5a, 0x86, 0x79, 0x09, 0x9f, 0xca, 0xf6, 0xf0, 0x2e, 0xf1, 0x1a, 0x52, 0x6d, 0xdc,
+	0x9e, 0x3c, 0x8b, 0xcb, 0xa4, 0x2e, 0xf8, 0x31, 0x21, 0x83, 0x99, 0xed, 0xa4, 0xea, 0x94, 0x0c,
+	0xb7, 0xcf, 0xf9, 0x29, 0x22, 0xc2, 0x84, 0xda, 0x8d, 0x67, 0x93, 0x32, 0xa9, 0x33, 0x1e, 0x6a,
+	0x7c, 0x84, 0xbc, 0x3d, 0xaf, 0xc7, 0xa6, 0x65, 0x54, 0xe7, 0xcb, 0x8b, 0xc6, 0x89, 0x66, 0xb4,
+	0x35, 0x1f, 0xf7, 0xe0, 0x1d, 0x64, 0xa4, 0x77, 0x7d, 0xdc, 0x39, 0x96, 0x06, 0xa5, 0x33, 0xa8,
+	0x5e, 0xe0, 0xff, 0xd1, 0xde, 0x3b, 0x6b, 0xbc, 0x0a, 0x3e, 0x7b, 0x0a, 0xa2, 0x51, 0x10, 0x3d,
+	0xc5, 0x3f, 0x9f, 0xf8, 0xec, 0xb3, 0x7c, 0x86, 0x59, 0x38, 0xae, 0x3a, 0x6c, 0x60, 0x1a, 0x4a,
+	0xbc, 0xec, 0x75, 0xc6, 0x4f, 0x72, 0xb3, 0x18, 0x91, 0x61, 0x4c, 0xf5, 0x4f, 0xa4, 0xe1, 0xd3,
+	0x9e, 0x7e, 0x07, 0x00, 0x6b, 0x7a, 0x65, 0xfc, 0xc9, 0x01, 0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// BlinderClient is the client API for Blinder service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type BlinderClient interface {
+	Blind(ctx context.Context, in *BlindRequest, opts ...grpc.CallOption) (*BlindResponse, error)
+}
+
+type blinderClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewBlinderClient(cc *grpc.ClientConn) BlinderClient {
+	return &blinderClient{cc}
+}
+
+func (c *blinderClient) Blind(ctx context.Context, in *BlindRequest, opts ...grpc.CallOption) (*BlindResponse, error) {
+	out := new(BlindResponse)
+	err := c.cc.Invoke(ctx, "/pb.Blinder/Blind", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// BlinderServer is the server API for Blinder service.
+type BlinderServer interface {
+	Blind(context.Context, *BlindRequest) (*BlindResponse, error)
+}
+
+// UnimplementedBlinderServer can be embedded to have forward compatible implementations.
+type UnimplementedBlinderServer struct {
+}
+
+func (*UnimplementedBlinderServer) Blind(ctx context.Context, req *BlindRequest) (*BlindResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Blind not implemented")
+}
+
+func RegisterBlinderServer(s *grpc.Server, srv BlinderServer) {
+	s.RegisterService(&_Blinder_serviceDesc, srv)
+}
+
+func _Blinder_Blind_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BlindRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BlinderServer).Blind(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Blinder/Blind",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BlinderServer).Blind(ctx, req.(*BlindRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Blinder_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "pb.Blinder",
+	HandlerType: (*BlinderServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Blind",
+			Handler:    _Blinder_Blind_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "pb/blinding.proto",
+}
@@ -0,0 +1,144 @@
+package blinding
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type tokenBucket struct {
+	Tokens  float64
+	Updated time.Time
+}
+
+// RateLimiter allows each client PerDay requests per day, with bursts of up
+// to Burst requests, using a token bucket per client.
+type RateLimiter struct {
+	PerDay float64
+	Burst  float64
+
+	Now func() time.Time // defaults to time.Now
+
+	buckets map[string]*tokenBucket
+	mu      sync.Mutex
+}
+
+func NewRateLimiter(perDay, burst float64) *RateLimiter {
+	return &RateLimiter{
+		PerDay:  perDay,
+		Burst:   burst,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+func (rl *RateLimiter) now() time.Time {
+	if rl.Now != nil {
+		return rl.Now()
+	}
+	return time.Now()
+}
+
+// refill must be called with rl.mu held.
+func (rl *RateLimiter) refill(b *tokenBucket, now time.Time) {
+	elapsed := now.Sub(b.Updated)
+	if elapsed <= 0 {
+		return
+	}
+	b.Tokens += rl.PerDay * elapsed.Hours() / 24
+	if b.Tokens > rl.Burst {
+		b.Tokens = rl.Burst
+	}
+	b.Updated = now
+}
+
+// Allow takes a token from clientID's bucket, if there is one.
+func (rl *RateLimiter) Allow(clientID string) bool {
+	now := rl.now()
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	b, ok := rl.buckets[clientID]
+	if !ok {
+		b = &tokenBucket{Tokens: rl.Burst, Updated: now}
+		rl.buckets[clientID] = b
+	}
+	rl.refill(b, now)
+	if b.Tokens < 1 {
+		return false
+	}
+	b.Tokens--
+	return true
+}
+
+// prune drops full buckets, which are equivalent to missing ones. It must be
+// called with rl.mu held.
+func (rl *RateLimiter) prune(now time.Time) {
+	for clientID, b := range rl.buckets {
+		rl.refill(b, now)
+		if b.Tokens >= rl.Burst {
+			delete(rl.buckets, clientID)
+		}
+	}
+}
+
+// Save atomically writes the state of all buckets to path.
+func (rl *RateLimiter) Save(path string) error {
+	rl.mu.Lock()
+	rl.prune(rl.now())
+	state, err := json.Marshal(rl.buckets)
+	rl.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(state); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Load restores the state written by Save. A missing file is not an error.
+func (rl *RateLimiter) Load(path string) error {
+	state, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	buckets := make(map[string]*tokenBucket)
+	if err := json.Unmarshal(state, &buckets); err != nil {
+		return err
+	}
+	rl.mu.Lock()
+	rl.buckets = buckets
+	rl.mu.Unlock()
+	return nil
+}
+
+// Persist periodically saves the state to path.
+func (rl *RateLimiter) Persist(path string, interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if err := rl.Save(path); err != nil {
+			log.Printf("error while saving rate limiter state: %s", err.Error())
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package blinding
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	start := time.Unix(1000, 0)
+	for _, tc := range []struct {
+		name    string
+		perDay  float64
+		burst   float64
+		elapsed time.Duration // before the second round of requests
+		allowed []int         // requests allowed in each round of 5
+	}{
+		{"burst", 24, 3, 0, []int{3, 0}},
+		{"refill", 24, 3, 2 * time.Hour, []int{3, 2}},
+		{"partial refill", 24, 3, 90 * time.Minute, []int{3, 1}},
+		{"refill up to burst", 24, 3, 48 * time.Hour, []int{3, 3}},
+		{"no burst", 24, 0, time.Hour, []int{0, 0}},
+	} {
+		now := start
+		rl := NewRateLimiter(tc.perDay, tc.burst)
+		rl.Now = func() time.Time { return now }
+		for round, want := range tc.allowed {
+			if round > 0 {
+				now = now.Add(tc.elapsed)
+			}
+			got := 0
+			for i := 0; i < 5; i++ {
+				if rl.Allow("client") {
+					got++
+				}
+			}
+			if got != want {
+				t.Errorf("%s: round %d allowed %d requests, want %d", tc.name, round, got, want)
+			}
+		}
+		// clients have their own buckets
+		if tc.burst >= 1 && !rl.Allow("other client") {
+			t.Errorf("%s: another client was limited", tc.name)
+		}
+	}
+}
+
+func TestRateLimiterSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ratelimit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	now := time.Unix(1000, 0)
+	rl := NewRateLimiter(24, 2)
+	rl.Now = func() time.Time { return now }
+	// a missing file is not an error
+	if err := rl.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	rl.Allow("a")
+	rl.Allow("a")
+	rl.Allow("b")
+	if err := rl.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewRateLimiter(24, 2)
+	restored.Now = func() time.Time { return now }
+	if err := restored.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Allow("a") {
+		t.Errorf("client a got another request after restoring the state")
+	}
+	if !restored.Allow("b") || restored.Allow("b") {
+		t.Errorf("client b did not get exactly one more request")
+	}
+
+	// full buckets are not saved
+	now = now.Add(48 * time.Hour)
+	if err := restored.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	if state, err := ioutil.ReadFile(path); err != nil || string(state) != "{}" {
+		t.Errorf("saved %q (error %v), want no buckets", state, err)
+	}
+}
@@ -9,3 +9,7 @@ echo
 echo
 echo Sending invalid test query "(input wrong length)".
 curl -d '{"DayID": 1, "Inputs": ["203C1061B82AEC4F5C9A71CD5C07047A11A65F7F972EE1CA532A3A32A301521", "12E736A142AC1BFC423F57199E60EC644EEA5E44BE0D92EFA6643D2AAD73F2F"]}' -X POST http://localhost:8787/v0/blind
+echo
+echo
+echo Sending valid ordered test query with tags.
+curl -d '{"DayID": 1, "Inputs": ["203C1D0461B82AEC4F5C9A71CD5C07047A11A65F7F972EE1CA532A3A32A30152", "1E9736A142AC1BFC423F57199E60EC644EEA5E44BE0D92EFA6643D2AAD7D3F2F"], "Ordered": true, "Tags": ["alice", "bob"]}' -X POST http://localhost:8787/v0/blind
@@ -1,18 +1,146 @@
 package main
 
 import (
+	"bytes"
+	"crypto/ed25519"
 	"flag"
 	"github.com/yunwilliamyu/contact-trace-mixnet/blinding"
+	"io/ioutil"
 	"log"
+	"os"
+	"time"
 )
 
 var listenAddr = flag.String("listen_addr", ":8787", "address to listen on")
+var grpcListenAddr = flag.String("grpc_listen_addr", "", "address to serve the gRPC service on, if any")
 var keyDir = flag.String("key_dir", "", "directory to read keys from")
+var keySource = flag.String("key_source", "dir", "where to read keys from: dir (plaintext files in key_dir), sealed (encrypted files in key_dir) or softhsm")
+var passphraseFile = flag.String("passphrase_file", "", "sealed: file containing the passphrase the keys are sealed with")
+var secretKeyFile = flag.String("secret_key_file", "", "sealed: file containing the 32-byte secretbox key the keys are sealed with, instead of a passphrase")
+var softHSMDir = flag.String("softhsm_dir", "", "softhsm: directory of the software token")
+var maxPastDays = flag.Int("max_past_days", 14, "refuse to serve days more than this many days in the past")
+var maxFutureDays = flag.Int("max_future_days", 1, "refuse to serve days more than this many days in the future")
+var retentionDays = flag.Int("retention_days", 14, "erase keys more than this many days old")
+var autoKeygen = flag.Bool("auto_keygen", false, "generate upcoming keys while serving")
+var maintenanceInterval = flag.Duration("maintenance_interval", time.Hour, "how often to expire (and generate) keys")
+var attestationCA = flag.String("attestation_ca_pubkey", "", "file containing the raw Ed25519 public key of the local CA certifying device keys")
+var noAttestation = flag.Bool("insecure_no_attestation", false, "accept requests without attestation; only for testing")
+var maxInputs = flag.Int("max_inputs", 1000, "maximum number of inputs per request, 0 for unlimited")
+var requestsPerDay = flag.Float64("requests_per_day", 0, "requests allowed per client per day, 0 for unlimited")
+var requestBurst = flag.Float64("request_burst", 10, "requests a client may make in a burst")
+var rateLimitState = flag.String("rate_limit_state", "", "file to persist the rate limiter state in")
+var rateLimitSaveInterval = flag.Duration("rate_limit_save_interval", time.Minute, "how often to persist the rate limiter state")
+var keygenFrom = flag.Int("from", 0, "keygen: first day to generate a key for (default today)")
+var keygenTo = flag.Int("to", 0, "keygen: last day to generate a key for (default today+max_future_days)")
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "keygen" {
+		flag.CommandLine.Parse(os.Args[2:])
+		keygen()
+		return
+	}
 	flag.Parse()
 
-	kr := blinding.KeyReader{Dir: *keyDir}
-	b := blinding.New(kr.ReadKey)
+	km := keyManager()
+	if *retentionDays < *maxPastDays {
+		log.Fatalf("-retention_days=%d would erase keys that are still served (-max_past_days=%d)", *retentionDays, *maxPastDays)
+	}
+	b := blinding.New(km)
+	b.CheckDay = km.CheckDay
+	b.Verifier = attestationVerifier()
+	b.MaxInputs = *maxInputs
+	if *requestsPerDay > 0 {
+		b.Limiter = blinding.NewRateLimiter(*requestsPerDay, *requestBurst)
+		if *rateLimitState != "" {
+			if err := b.Limiter.Load(*rateLimitState); err != nil {
+				log.Fatal(err)
+			}
+			go b.Limiter.Persist(*rateLimitState, *rateLimitSaveInterval)
+		}
+	}
+	go km.Maintain(b, *maintenanceInterval, *autoKeygen)
+	if *grpcListenAddr != "" {
+		go func() {
+			log.Fatal(b.RunGRPC(*grpcListenAddr))
+		}()
+	}
 	log.Fatal(b.Run(*listenAddr))
 }
+
+func keyManager() *blinding.KeyManager {
+	km := &blinding.KeyManager{
+		Dir:           *keyDir,
+		MaxPastDays:   *maxPastDays,
+		MaxFutureDays: *maxFutureDays,
+		RetentionDays: *retentionDays,
+	}
+	switch *keySource {
+	case "dir":
+	case "sealed":
+		skr := blinding.SealedKeyReader{Dir: *keyDir}
+		if *secretKeyFile != "" {
+			secretKey, err := ioutil.ReadFile(*secretKeyFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if len(secretKey) != 32 {
+				log.Fatalf("secret key is %d bytes long instead of %d", len(secretKey), 32)
+			}
+			skr.SecretKey = new([32]byte)
+			copy(skr.SecretKey[:], secretKey)
+		} else {
+			passphrase, err := ioutil.ReadFile(*passphraseFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			skr.Passphrase = bytes.TrimRight(passphrase, "\n")
+		}
+		km.Source = skr
+		km.Seal = skr.Seal
+	case "softhsm":
+		// keys live in the token, so there are no files for us to manage
+		km.Dir = ""
+		km.Source = blinding.HSMKeySource{Token: &blinding.SoftToken{Dir: *softHSMDir}}
+	default:
+		log.Fatalf("unknown -key_source %q", *keySource)
+	}
+	return km
+}
+
+func attestationVerifier() blinding.AttestationVerifier {
+	if *noAttestation {
+		return blinding.NoAttestation{}
+	}
+	avs := blinding.AttestationVerifiers{}
+	if *attestationCA != "" {
+		caPublicKey, err := ioutil.ReadFile(*attestationCA)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(caPublicKey) != ed25519.PublicKeySize {
+			log.Fatalf("CA public key is %d bytes long instead of %d", len(caPublicKey), ed25519.PublicKeySize)
+		}
+		avs["ed25519"] = blinding.Ed25519Verifier{CAPublicKey: caPublicKey}
+	}
+	if len(avs) == 0 {
+		log.Print("no attestation verifiers configured, all requests will be rejected")
+	}
+	return avs
+}
+
+func keygen() {
+	km := keyManager()
+	if km.Dir == "" {
+		log.Fatalf("keygen needs -key_dir and is not supported for -key_source=%s", *keySource)
+	}
+	from, to := *keygenFrom, *keygenTo
+	if from == 0 {
+		from = km.Today()
+	}
+	if to == 0 {
+		to = km.Today() + km.MaxFutureDays
+	}
+	if err := km.Generate(from, to); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/yunwilliamyu/contact-trace-mixnet/configs"
+	"github.com/yunwilliamyu/contact-trace-mixnet/mixnet"
+	"log"
+	"os"
+	"time"
+)
+
+var (
+	config   = flag.String("config_file", "", "server config file of the chain to audit, in json format")
+	maxDelay = flag.Duration("max_delay", 5*time.Minute, "time after which onions must have been committed to by the next node, and their links revealed")
+)
+
+func main() {
+	flag.Parse()
+
+	conf := &mixnet.MixnetServerConfig{}
+	if err := configs.LoadConfig(*config, conf); err != nil {
+		log.Fatal(err)
+	}
+	mc, err := mixnet.MakeClientConfig(conf)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	chain := make([]mixnet.NodeAudit, len(conf.Addrs))
+	for idx := range conf.Addrs {
+		chain[idx].PubKey = mc.PubKeys[idx]
+		for _, addr := range conf.PositionAddrs(idx) {
+			audits, err := mixnet.FetchAudits(addr)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if len(audits) > 0 && audits[0].Seq != 1 {
+				chain[idx].Truncated = true
+			}
+			chain[idx].Batches = append(chain[idx].Batches, audits...)
+		}
+		fmt.Printf("node %d: %d batches\n", idx, len(chain[idx].Batches))
+	}
+
+	r := mixnet.CheckChain(chain, time.Now().Add(-*maxDelay))
+	fmt.Printf("%d onions not yet committed to by the next node or revealed\n", r.Pending)
+	for _, err := range r.Errors {
+		fmt.Println(err)
+	}
+	if len(r.Errors) > 0 {
+		os.Exit(1)
+	}
+	fmt.Println("ok")
+}
@@ -7,9 +7,11 @@ import (
 	"io"
 	"log"
 	"os"
+	"time"
 )
 
 var config = flag.String("config", "", "JSON file with client configuration")
+var receiptTimeout = flag.Duration("receipt_timeout", 0, "if set, wait for the receipts of all messages, and resend those without one after this long")
 
 func main() {
 	flag.Parse()
@@ -20,15 +22,32 @@ func main() {
 	}
 
 	mc := mixnet.NewMixnetClient(conf)
+	send := mc.SendMessage
+	var rt *mixnet.ReceiptTracker
+	if *receiptTimeout > 0 {
+		var err error
+		rt, err = mixnet.NewReceiptTracker(mc, *receiptTimeout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		send = rt.Send
+	}
 	for {
 		buf := make([]byte, conf.MessageLength)
 		if _, err := io.ReadFull(os.Stdin, buf); err != nil {
 			if err == io.EOF {
-				return
+				break
 			}
 			log.Fatal(err)
 		}
-		if err := mc.SendMessage(buf); err != nil {
+		if err := send(buf); err != nil {
+			log.Print(err)
+		}
+	}
+
+	for rt != nil && rt.Pending() > 0 {
+		time.Sleep(time.Second)
+		if _, err := rt.Poll(); err != nil {
 			log.Print(err)
 		}
 	}
@@ -7,19 +7,31 @@ import (
 	"github.com/yunwilliamyu/contact-trace-mixnet/mixnet"
 	"log"
 	"os"
+	"strings"
 )
 
-var config = flag.String("config_file", "", "server config file, in json format")
+var config = flag.String("config_file", "", "server config file, in json format; several comma-separated ones for several cascades")
+var weighted = flag.Bool("weighted", false, "choose cascades in proportion to their advertised capacity instead of uniformly")
 
 func main() {
 	flag.Parse()
 
-	conf := &mixnet.MixnetServerConfig{}
-	if err := configs.LoadConfig(*config, conf); err != nil {
-		log.Fatal(err)
+	var confs []*mixnet.MixnetServerConfig
+	for _, path := range strings.Split(*config, ",") {
+		conf := &mixnet.MixnetServerConfig{}
+		if err := configs.LoadConfig(path, conf); err != nil {
+			log.Fatal(err)
+		}
+		confs = append(confs, conf)
 	}
 
-	mc, err := mixnet.MakeClientConfig(conf)
+	var mc *mixnet.MixnetClientConfig
+	var err error
+	if len(confs) == 1 {
+		mc, err = mixnet.MakeClientConfig(confs[0])
+	} else {
+		mc, err = mixnet.MakeCascadesClientConfig(confs, *weighted)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
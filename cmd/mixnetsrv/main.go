@@ -4,16 +4,26 @@ import (
 	"flag"
 	"github.com/yunwilliamyu/contact-trace-mixnet/configs"
 	"github.com/yunwilliamyu/contact-trace-mixnet/mixnet"
+	"github.com/yunwilliamyu/contact-trace-mixnet/mixnet/sink"
+	"github.com/yunwilliamyu/contact-trace-mixnet/notifier"
+	"github.com/yunwilliamyu/contact-trace-mixnet/store"
 	"io/ioutil"
 	"log"
 	"os"
-	"sync"
 )
 
 var masterKeyFile = flag.String("master_key_file", "PROVIDE MASTER KEY", "Path to the master secret key")
 var listenAddr = flag.String("listen_addr", "PROVIDE LISTEN ADDR", "Address to bind to")
 var idx = flag.Int("idx", 0, "Index in the mixnet, counting from the end") // TODO: relieve the need to specify this
 var config = flag.String("config_file", "", "path to the location of the config file in json format")
+var sinkType = flag.String("sink", "stdout", "idx 0: where to push batches: stdout, file, unix, notifier, store, or http (to OutputAddr)")
+var sinkFormat = flag.String("sink_format", "length_prefixed", "stdout, file, unix: length_prefixed or protobuf")
+var sinkPath = flag.String("sink_path", "", "file: directory to write to; unix: path of the socket")
+var sinkMaxFileSize = flag.Int64("sink_max_file_size", 64<<20, "file: start a new file after this many bytes")
+var notifierConfig = flag.String("notifier_config", "", "path to the notifier.Config to deliver to, for -sink=notifier and for replies delivered by the entry node")
+var storeMasterKeyFile = flag.String("store_master_key_file", "", "store: run the database store in this node, with this master key")
+var storeListenAddr = flag.String("store_listen_addr", "", "store: address to serve the mailboxes of the store on")
+var storeDBPath = flag.String("store_db_path", "", "store: keep the mailboxes of the store in this bolt database instead of in memory")
 
 func main() {
 	flag.Parse()
@@ -30,15 +40,67 @@ func main() {
 
 	ms := mixnet.NewMixnetServer(conf, *idx, string(masterKey))
 	if *idx == 0 {
-		var mu sync.Mutex
-		ms.PushHandler = func(msgs [][]byte) error {
-			mu.Lock()
-			for _, msg := range msgs {
-				os.Stdout.Write(msg) // TODO: nondelimited output here seems like a very bad idea
+		ms.PushHandler = pushHandler(conf)
+	}
+	if *idx == len(conf.Addrs)-1 && *notifierConfig != "" {
+		ms.ReplyHandler = (&sink.NotifierSink{Notifier: notifierClient()}).Push
+	}
+	log.Fatal(ms.Run(*listenAddr))
+}
+
+func notifierClient() *notifier.NotifierClient {
+	nconf := &notifier.Config{}
+	if err := configs.LoadConfig(*notifierConfig, nconf); err != nil {
+		log.Fatal(err)
+	}
+	nc, err := notifier.NewNotifierClient(nconf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return nc
+}
+
+// pushHandler returns the PushHandler of the final node selected by -sink.
+func pushHandler(conf *mixnet.MixnetServerConfig) func([][]byte) error {
+	format, err := sink.ParseFormat(*sinkFormat)
+	if err != nil {
+		log.Fatal(err)
+	}
+	switch *sinkType {
+	case "stdout":
+		return (&sink.WriterSink{W: os.Stdout, Format: format}).Push
+	case "file":
+		return (&sink.FileSink{Dir: *sinkPath, Format: format, MaxFileSize: *sinkMaxFileSize}).Push
+	case "unix":
+		return (&sink.UnixSocketSink{Path: *sinkPath, Format: format}).Push
+	case "notifier":
+		return (&sink.NotifierSink{Notifier: notifierClient()}).Push
+	case "store":
+		storeMasterKey, err := ioutil.ReadFile(*storeMasterKeyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var db store.DB = &store.InMemoryDB{}
+		if *storeDBPath != "" {
+			bdb, err := store.OpenBoltDB(*storeDBPath)
+			if err != nil {
+				log.Fatal(err)
 			}
-			mu.Unlock()
-			return nil
+			db = bdb
 		}
+		s := store.NewStore(string(storeMasterKey), conf.MessageLength, db)
+		s.ReplyAddr = conf.Addrs[0]
+		if *storeListenAddr != "" {
+			go func() {
+				log.Fatal(s.Run(*storeListenAddr))
+			}()
+		}
+		return s.Receive
+	case "http":
+		// MixnetServer pushes to OutputAddr by itself
+		return nil
+	default:
+		log.Fatalf("unknown -sink %q", *sinkType)
+		return nil
 	}
-	log.Fatal(ms.Run(*listenAddr))
 }
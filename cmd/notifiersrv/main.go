@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/yunwilliamyu/contact-trace-mixnet/notifier"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+)
+
+var masterKeyFile = flag.String("master_key_file", "PROVIDE MASTER KEY", "Path to the master secret key")
+var listenAddr = flag.String("listen_addr", "PROVIDE LISTEN ADDR", "Address to bind to")
+var serverAddr = flag.String("server_addr", "", "pubkey: address clients should dial")
+var dbType = flag.String("db", "memory", "dead drop storage: memory, bolt, sqlite or postgres")
+var ttl = flag.Duration("ttl", 14*24*time.Hour, "how long to keep notifications, 0 to keep them forever")
+var reapInterval = flag.Duration("reap_interval", time.Hour, "how often to delete expired notifications")
+var dbPath = flag.String("db_path", "", "bolt, sqlite: path of the database file; postgres: connection string")
+
+// openDB opens the dead drop storage selected with -db, and returns a
+// function which closes it.
+func openDB(dbType, dbPath string) (notifier.DB, func() error, error) {
+	switch dbType {
+	case "memory":
+		return &notifier.InMemoryDB{}, func() error { return nil }, nil
+	case "bolt":
+		bdb, err := notifier.OpenBoltDB(dbPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		return bdb, bdb.Close, nil
+	case "sqlite", "postgres":
+		driverName := map[string]string{"sqlite": "sqlite3", "postgres": "postgres"}[dbType]
+		sdb, err := notifier.OpenSQLDB(driverName, dbPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		return sdb, sdb.Close, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown -db %q", dbType)
+	}
+}
+
+func main() {
+	pubkey := len(os.Args) > 1 && os.Args[1] == "pubkey"
+	if pubkey {
+		flag.CommandLine.Parse(os.Args[2:])
+	} else {
+		flag.Parse()
+	}
+
+	masterKey, err := ioutil.ReadFile(*masterKeyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	conf := &notifier.Config{
+		ServerAddr: *serverAddr,
+		PublicKey:  notifier.PubKey(string(masterKey)),
+	}
+	if pubkey {
+		json.NewEncoder(os.Stdout).Encode(conf)
+		return
+	}
+
+	db, closeDB, err := openDB(*dbType, *dbPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer closeDB()
+
+	ps := notifier.NewPollServer(string(masterKey), db)
+	ps.TTL = *ttl
+	go ps.RunReaper(*reapInterval)
+	log.Fatal(ps.Run(*listenAddr))
+}
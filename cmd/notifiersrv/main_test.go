@@ -0,0 +1,52 @@
+package main
+
+import (
+	"github.com/yunwilliamyu/contact-trace-mixnet/notifier"
+	"github.com/yunwilliamyu/contact-trace-mixnet/notifier/pb"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "notifiersrv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if *dbType != "memory" {
+		t.Errorf("-db defaults to %q, want memory", *dbType)
+	}
+	for _, tc := range []struct {
+		dbType, dbPath string
+		ok             bool
+	}{
+		{"memory", "", true},
+		{"bolt", filepath.Join(dir, "notifier.db"), true},
+		{"sqlite", filepath.Join(dir, "notifier.sqlite"), true},
+		{"bolt", filepath.Join(dir, "missing", "notifier.db"), false},
+		{"mysql", "", false},
+	} {
+		db, closeDB, err := openDB(tc.dbType, tc.dbPath)
+		if !tc.ok {
+			if err == nil {
+				closeDB()
+				t.Errorf("-db=%s -db_path=%s: no error", tc.dbType, tc.dbPath)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("-db=%s: %s", tc.dbType, err.Error())
+			continue
+		}
+		var id notifier.DeadDropID
+		if err := db.Put(id, &pb.Notification{Contents: []byte("hello")}); err != nil {
+			t.Errorf("-db=%s: Put: %s", tc.dbType, err.Error())
+		}
+		if err := closeDB(); err != nil {
+			t.Errorf("-db=%s: close: %s", tc.dbType, err.Error())
+		}
+	}
+}
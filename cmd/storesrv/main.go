@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"github.com/yunwilliamyu/contact-trace-mixnet/configs"
+	"github.com/yunwilliamyu/contact-trace-mixnet/mixnet"
+	"github.com/yunwilliamyu/contact-trace-mixnet/notifier"
+	"github.com/yunwilliamyu/contact-trace-mixnet/store"
+	"io/ioutil"
+	"log"
+	"time"
+)
+
+var masterKeyFile = flag.String("master_key_file", "PROVIDE MASTER KEY", "Path to the master secret key")
+var listenAddr = flag.String("listen_addr", "PROVIDE LISTEN ADDR", "Address to bind to")
+var config = flag.String("config_file", "", "path to the location of the mixnet config file in json format")
+var dbType = flag.String("db", "memory", "mailbox storage: memory or bolt")
+var dbPath = flag.String("db_path", "", "bolt: path of the database file")
+var notifierConfig = flag.String("notifier_config", "", "path to the notifier.Config of the polling gateway; forwarding is disabled without it")
+var forwardInterval = flag.Duration("forward_interval", time.Minute, "how often to forward mail to subscribers")
+var subscriptionTTL = flag.Duration("subscription_ttl", 14*24*time.Hour, "how long subscriptions last unless renewed, 0 to keep them forever")
+
+func openDB() (store.DB, error) {
+	switch *dbType {
+	case "memory":
+		return &store.InMemoryDB{}, nil
+	case "bolt":
+		return store.OpenBoltDB(*dbPath)
+	default:
+		log.Fatalf("unknown -db %q", *dbType)
+		return nil, nil
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	conf := &mixnet.MixnetServerConfig{}
+	if err := configs.LoadConfig(*config, conf); err != nil {
+		log.Fatal(err)
+	}
+
+	masterKey, err := ioutil.ReadFile(*masterKeyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := openDB()
+	if err != nil {
+		log.Fatal(err)
+	}
+	s := store.NewStore(string(masterKey), conf.MessageLength, db)
+	if len(conf.Addrs) > 0 {
+		// replies go back through the node which pushes to the store
+		s.ReplyAddr = conf.Addrs[0]
+	}
+	if *notifierConfig != "" {
+		nconf := &notifier.Config{}
+		if err := configs.LoadConfig(*notifierConfig, nconf); err != nil {
+			log.Fatal(err)
+		}
+		nc, err := notifier.NewNotifierClient(nconf)
+		if err != nil {
+			log.Fatal(err)
+		}
+		s.Notifier = nc
+		s.SubscriptionTTL = *subscriptionTTL
+		go s.RunForwarder(*forwardInterval)
+	}
+	log.Fatal(s.Run(*listenAddr))
+}
@@ -5,6 +5,9 @@ go 1.13
 require (
 	github.com/dgraph-io/ristretto v0.0.2
 	github.com/golang/protobuf v1.4.0-rc.4
+	github.com/lib/pq v1.5.2
+	github.com/mattn/go-sqlite3 v1.14.0
+	go.etcd.io/bbolt v1.3.5
 	golang.org/x/crypto v0.0.0-20200403201458-baeed622b8d8
 	google.golang.org/grpc v1.28.0
 	google.golang.org/protobuf v1.20.1
@@ -0,0 +1,507 @@
+package mixnet
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/nacl/box"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// In audit mode, every node publishes a BatchAudit for each batch it pushes,
+// committing to the hashes of the onions it received and of those it pushed.
+// Dropped, duplicated or injected onions show up as a mismatch between the
+// outputs of a node and the inputs of the next one, and every client can
+// check that its onion went through each node (see SendMessageAudited).
+//
+// To check that the outputs are the decrypted inputs without linking them
+// all, nodes use randomized partial checking: the node with odd idx and the
+// node with idx-1 form a pair, and for every onion passed between them,
+// exactly one of them reveals the key which decrypts it, depending on a bit
+// derived from the roots of the batches of both nodes. The odd node cannot
+// choose the bits, as the root of the next node is only known once its own
+// root is published, so it reveals its links once the next node published
+// the batch with the onion (see revealLoop). No onion is linked through a
+// whole pair, and a node that replaces k onions is caught with probability
+// 1-2^-k. The entry node of a chain of odd length is not paired.
+
+// maxAuditBatches is the number of batches whose audits a node keeps.
+const maxAuditBatches = 1000
+
+// auditRevealInterval is how often odd nodes fetch the audits of the next
+// node to reveal their links.
+const auditRevealInterval = 10 * time.Second
+
+// BatchAudit is the commitment of a node to one batch.
+type BatchAudit struct {
+	Seq     uint64
+	Time    int64      // unix time in nanoseconds at which the batch was pushed
+	Inputs  [][32]byte // sorted hashes of the onions received
+	Outputs [][32]byte // sorted hashes of the onions pushed
+	Root    [32]byte
+	Links   []Link
+
+	// links to reveal once the next node committed to the outputs, by the
+	// hash of the output
+	unrevealed map[[32]byte]Link
+}
+
+// Link reveals how one of the inputs of a batch was decrypted.
+type Link struct {
+	Input []byte
+	Key   [32]byte // shared key of the box of this layer
+}
+
+type auditEntry struct {
+	input        []byte
+	key          [32]byte
+	upstreamRoot []byte
+}
+
+type unrevealedLink struct {
+	seq  uint64 // of the batch which pushed the output
+	root [32]byte
+	link Link
+}
+
+type auditLog struct {
+	pending    map[[32]byte]auditEntry     // by hash of the output
+	unrevealed map[[32]byte]unrevealedLink // by hash of the output
+	batches    []*BatchAudit
+	seq        uint64
+	mu         sync.Mutex
+}
+
+func newAuditLog() *auditLog {
+	return &auditLog{
+		pending:    make(map[[32]byte]auditEntry),
+		unrevealed: make(map[[32]byte]unrevealedLink),
+	}
+}
+
+func auditRoot(inputs [][32]byte, outputs [][32]byte) [32]byte {
+	h := sha256.New()
+	h.Write([]byte("MIXNET_AUDIT"))
+	binary.Write(h, binary.BigEndian, uint32(len(inputs)))
+	for _, in := range inputs {
+		h.Write(in[:])
+	}
+	binary.Write(h, binary.BigEndian, uint32(len(outputs)))
+	for _, out := range outputs {
+		h.Write(out[:])
+	}
+	var root [32]byte
+	copy(root[:], h.Sum(nil))
+	return root
+}
+
+// challenge selects who reveals the link of an onion passed between a pair,
+// given the roots of the batches of the odd node and of the even one: the odd
+// node if it is true, the even node otherwise.
+func challenge(oddRoot []byte, evenRoot []byte, hash [32]byte) bool {
+	h := sha256.New()
+	h.Write([]byte("MIXNET_CHALLENGE"))
+	h.Write(oddRoot)
+	h.Write(evenRoot)
+	h.Write(hash[:])
+	return h.Sum(nil)[0]&1 == 1
+}
+
+func sortHashes(hashes [][32]byte) {
+	sort.Slice(hashes, func(i, j int) bool {
+		return bytes.Compare(hashes[i][:], hashes[j][:]) < 0
+	})
+}
+
+// add records that msg was received and decrypted to out.
+func (al *auditLog) add(k keys, msg []byte, out []byte, upstreamRoot []byte) error {
+	var epk, key [32]byte
+	copy(epk[:], msg)
+	box.Precompute(&key, &epk, &k.privateKey)
+	hash := sha256.Sum256(out)
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	// a replayed onion would be pushed twice, which is exactly what the
+	// audit is meant to catch
+	if _, ok := al.pending[hash]; ok {
+		return fmt.Errorf("received duplicate onion")
+	}
+	al.pending[hash] = auditEntry{input: msg, key: key, upstreamRoot: upstreamRoot}
+	return nil
+}
+
+// commit returns the audit of a batch of the node with idx in a chain of
+// chainLength nodes.
+func (al *auditLog) commit(idx int, chainLength int, onions [][]byte) *BatchAudit {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	b := &BatchAudit{}
+	entries := make([]auditEntry, len(onions))
+	outputs := make([][32]byte, len(onions))
+	for i, onion := range onions {
+		outputs[i] = sha256.Sum256(onion)
+		entries[i] = al.pending[outputs[i]]
+		b.Inputs = append(b.Inputs, sha256.Sum256(entries[i].input))
+		b.Outputs = append(b.Outputs, outputs[i])
+	}
+	sortHashes(b.Inputs)
+	sortHashes(b.Outputs)
+	b.Root = auditRoot(b.Inputs, b.Outputs)
+	for i, e := range entries {
+		link := Link{Input: e.input, Key: e.key}
+		if idx%2 == 1 {
+			// revealed once the next node committed to the output
+			if b.unrevealed == nil {
+				b.unrevealed = make(map[[32]byte]Link)
+			}
+			b.unrevealed[outputs[i]] = link
+		} else if idx+1 < chainLength && e.upstreamRoot != nil {
+			if !challenge(e.upstreamRoot, b.Root[:], sha256.Sum256(e.input)) {
+				b.Links = append(b.Links, link)
+			}
+		}
+	}
+	return b
+}
+
+// publish records that a batch was pushed.
+func (al *auditLog) publish(b *BatchAudit, onions [][]byte) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	for _, onion := range onions {
+		delete(al.pending, sha256.Sum256(onion))
+	}
+	al.seq++
+	b.Seq = al.seq
+	b.Time = time.Now().UnixNano()
+	for out, link := range b.unrevealed {
+		al.unrevealed[out] = unrevealedLink{seq: b.Seq, root: b.Root, link: link}
+	}
+	b.unrevealed = nil
+	al.batches = append(al.batches, b)
+	if len(al.batches) > maxAuditBatches {
+		al.batches = al.batches[len(al.batches)-maxAuditBatches:]
+		for out, u := range al.unrevealed {
+			if u.seq < al.batches[0].Seq {
+				delete(al.unrevealed, out)
+			}
+		}
+	}
+}
+
+// reveal publishes the links of the outputs which the next node committed to
+// in its batch next, if they are challenged.
+func (al *auditLog) reveal(next *BatchAudit) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	links := make(map[uint64][]Link)
+	for _, in := range next.Inputs {
+		u, ok := al.unrevealed[in]
+		if !ok {
+			continue
+		}
+		delete(al.unrevealed, in)
+		if challenge(u.root[:], next.Root[:], in) {
+			links[u.seq] = append(links[u.seq], u.link)
+		}
+	}
+	for i, b := range al.batches {
+		if len(links[b.Seq]) == 0 {
+			continue
+		}
+		// published audits are not modified, as they may be being encoded
+		revealed := *b
+		revealed.Links = append(append([]Link(nil), b.Links...), links[b.Seq]...)
+		al.batches[i] = &revealed
+	}
+}
+
+func (al *auditLog) after(seq uint64) []*BatchAudit {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	if seq > al.seq {
+		// the caller has seen batches from before a restart
+		seq = 0
+	}
+	i := sort.Search(len(al.batches), func(i int) bool {
+		return al.batches[i].Seq > seq
+	})
+	return append([]*BatchAudit(nil), al.batches[i:]...)
+}
+
+// ServeAudit returns the audits of the batches after the one with Seq
+// ?after= as a json list, or all of them if there is no such batch.
+func (ms *MixnetServer) ServeAudit(rw http.ResponseWriter, req *http.Request) {
+	if ms.audit == nil {
+		http.Error(rw, "audit mode is disabled", http.StatusNotFound)
+		return
+	}
+	var after uint64
+	if a := req.URL.Query().Get("after"); a != "" {
+		var err error
+		after, err = strconv.ParseUint(a, 10, 64)
+		if err != nil {
+			http.Error(rw, "invalid after", http.StatusBadRequest)
+			return
+		}
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(ms.audit.after(after)); err != nil {
+		log.Printf("error while writing audits: %s", err.Error())
+	}
+}
+
+// revealLoop fetches the audits of the replicas of the next node every
+// auditRevealInterval, and reveals the links they challenge.
+func (ms *MixnetServer) revealLoop() {
+	addrs := ms.conf.PositionAddrs(ms.idx - 1)
+	after := make([]uint64, len(addrs))
+	for {
+		time.Sleep(auditRevealInterval)
+		for i, addr := range addrs {
+			audits, err := fetchAudits(addr, after[i])
+			if err != nil {
+				log.Printf("error while fetching audits: %s", err.Error())
+				continue
+			}
+			for _, b := range audits {
+				if b.Seq <= after[i] {
+					// the next node restarted
+					after[i] = 0
+				}
+				ms.audit.reveal(b)
+				after[i] = b.Seq
+			}
+		}
+	}
+}
+
+// FetchAudits returns the audits published by the node at addr.
+func FetchAudits(addr string) ([]*BatchAudit, error) {
+	return fetchAudits(addr, 0)
+}
+
+func fetchAudits(addr string, after uint64) ([]*BatchAudit, error) {
+	resp, err := http.Get(fmt.Sprintf("%s/v0/audit?after=%d", addr, after))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("received %d (%s) from %s", resp.StatusCode, resp.Status, addr)
+	}
+	var audits []*BatchAudit
+	if err := json.NewDecoder(resp.Body).Decode(&audits); err != nil {
+		return nil, err
+	}
+	return audits, nil
+}
+
+// Open checks that the link is a valid decryption for the node with pubKey,
+// and returns the hash of the output.
+func (l *Link) Open(pubKey [32]byte) ([32]byte, error) {
+	var hash [32]byte
+	if len(l.Input) < 32 {
+		return hash, fmt.Errorf("link input too short")
+	}
+	h, err := blake2b.New(24, nil)
+	if err != nil {
+		return hash, err
+	}
+	h.Write(l.Input[:32])
+	h.Write(pubKey[:])
+	var nonce [24]byte
+	copy(nonce[:], h.Sum(nil))
+	out, ok := box.OpenAfterPrecomputation(nil, l.Input[32:], &nonce, &l.Key)
+	if !ok {
+		return hash, fmt.Errorf("link does not decrypt")
+	}
+	return sha256.Sum256(out), nil
+}
+
+// NodeAudit are the audits of all replicas of one node.
+type NodeAudit struct {
+	PubKey  [32]byte
+	Batches []*BatchAudit
+	// Truncated is set if older batches are missing, e.g. because the node
+	// does not keep them anymore.
+	Truncated bool
+}
+
+// AuditReport is the result of CheckChain.
+type AuditReport struct {
+	Errors []error
+	// Pending is the number of onions pushed after the deadline which the
+	// next node has not committed to, or whose link is not revealed yet.
+	Pending int
+}
+
+func (r *AuditReport) errorf(format string, args ...interface{}) {
+	r.Errors = append(r.Errors, fmt.Errorf(format, args...))
+}
+
+// batchRef is the batch of a node which received or pushed an onion.
+type batchRef struct {
+	root []byte
+	time int64
+}
+
+// CheckChain checks the audits of a chain, indexed like Addrs. Onions pushed
+// before deadline must have been committed to by the next node, and their
+// links revealed.
+func CheckChain(chain []NodeAudit, deadline time.Time) *AuditReport {
+	r := &AuditReport{}
+	inputs := make([]map[[32]byte]batchRef, len(chain))
+	outputs := make([]map[[32]byte]batchRef, len(chain))
+	oldest := make([]int64, len(chain)) // time of the first batch
+	for idx, node := range chain {
+		inputs[idx] = make(map[[32]byte]batchRef)
+		outputs[idx] = make(map[[32]byte]batchRef)
+		for _, b := range node.Batches {
+			ref := batchRef{root: b.Root[:], time: b.Time}
+			if oldest[idx] == 0 || b.Time < oldest[idx] {
+				oldest[idx] = b.Time
+			}
+			for _, in := range b.Inputs {
+				inputs[idx][in] = ref
+			}
+			for _, out := range b.Outputs {
+				if _, ok := outputs[idx][out]; ok {
+					r.errorf("node %d pushed %x twice", idx, out)
+				}
+				outputs[idx][out] = ref
+			}
+		}
+	}
+
+	for idx, node := range chain {
+		for _, b := range node.Batches {
+			checkBatch(r, idx, len(chain), node.PubKey, b, deadline, func(hash [32]byte) (batchRef, bool) {
+				if idx%2 == 1 {
+					ref, ok := inputs[idx-1][hash]
+					return ref, ok
+				}
+				if idx+1 < len(chain) {
+					ref, ok := outputs[idx+1][hash]
+					return ref, ok
+				}
+				return batchRef{}, false
+			})
+		}
+		if idx == 0 {
+			continue
+		}
+		for out, ref := range outputs[idx] {
+			if _, ok := inputs[idx-1][out]; ok {
+				continue
+			}
+			switch {
+			case chain[idx-1].Truncated && ref.time < oldest[idx-1]:
+				// the next node may not have kept its batch
+			case ref.time < deadline.UnixNano():
+				r.errorf("node %d pushed %x, which node %d did not commit to", idx, out, idx-1)
+			default:
+				r.Pending++
+			}
+		}
+		if !node.Truncated {
+			for in := range inputs[idx-1] {
+				if _, ok := outputs[idx][in]; !ok {
+					r.errorf("node %d received %x, which node %d did not push", idx-1, in, idx)
+				}
+			}
+		}
+	}
+	return r
+}
+
+// checkBatch checks batch b of the node with idx. paired returns the batch of
+// the other node of its pair which received or pushed an onion.
+func checkBatch(r *AuditReport, idx int, chainLength int, pubKey [32]byte, b *BatchAudit, deadline time.Time, paired func([32]byte) (batchRef, bool)) {
+	if len(b.Inputs) != len(b.Outputs) {
+		r.errorf("node %d batch %d has %d inputs and %d outputs", idx, b.Seq, len(b.Inputs), len(b.Outputs))
+	}
+	if b.Root != auditRoot(b.Inputs, b.Outputs) {
+		r.errorf("node %d batch %d has an invalid root", idx, b.Seq)
+		return
+	}
+	inputs := make(map[[32]byte]bool)
+	for _, in := range b.Inputs {
+		inputs[in] = true
+	}
+	outputs := make(map[[32]byte]bool)
+	for _, out := range b.Outputs {
+		outputs[out] = true
+	}
+
+	opened := make(map[[32]byte]bool) // outputs for odd nodes, inputs for even ones
+	for _, l := range b.Links {
+		in := sha256.Sum256(l.Input)
+		out, err := l.Open(pubKey)
+		if err != nil {
+			r.errorf("node %d batch %d: %s", idx, b.Seq, err.Error())
+			continue
+		}
+		if !inputs[in] || !outputs[out] {
+			r.errorf("node %d batch %d reveals a link which is not part of the batch", idx, b.Seq)
+			continue
+		}
+		if idx%2 == 1 {
+			opened[out] = true
+		} else {
+			opened[in] = true
+		}
+	}
+
+	// the node must not choose which links to reveal
+	if idx%2 == 1 {
+		for _, out := range b.Outputs {
+			next, ok := paired(out)
+			if !ok || !challenge(b.Root[:], next.root, out) || opened[out] {
+				continue
+			}
+			if next.time < deadline.UnixNano() {
+				r.errorf("node %d batch %d does not reveal the link of %x", idx, b.Seq, out)
+			} else {
+				r.Pending++
+			}
+		}
+	} else if idx+1 < chainLength {
+		for _, in := range b.Inputs {
+			prev, ok := paired(in)
+			if ok && !challenge(prev.root, b.Root[:], in) && !opened[in] {
+				r.errorf("node %d batch %d does not reveal the link of %x", idx, b.Seq, in)
+			}
+		}
+	}
+}
+
+// CheckOnion returns the number of nodes which committed to passing on the
+// onion with the given hashes (see SendMessageAudited), counting from the
+// entry node.
+func CheckOnion(chain []NodeAudit, hashes [][32]byte) int {
+	for idx := len(chain) - 1; idx >= 0; idx-- {
+		found := false
+		for _, b := range chain[idx].Batches {
+			i := sort.Search(len(b.Inputs), func(i int) bool {
+				return bytes.Compare(b.Inputs[i][:], hashes[idx][:]) >= 0
+			})
+			if i < len(b.Inputs) && b.Inputs[i] == hashes[idx] {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return len(chain) - 1 - idx
+		}
+	}
+	return len(chain)
+}
@@ -0,0 +1,148 @@
+package mixnet
+
+import (
+	"fmt"
+	"github.com/yunwilliamyu/contact-trace-mixnet/mixnet/pb"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// afterDeadline is a deadline after all batches of a test.
+var afterDeadline = time.Now().Add(time.Hour)
+
+// auditedChain sends count messages through a chain of depth audited nodes,
+// and returns the audits of the chain and the hashes of the onions.
+func auditedChain(t *testing.T, depth int, count int) ([]NodeAudit, [][][32]byte) {
+	msc := &MixnetServerConfig{
+		MinBatchSize:        count,
+		MessageLength:       messageLength,
+		MaxBufferedMessages: 1000,
+		Addrs:               make([]string, depth),
+		Audit:               true,
+	}
+	servers := make([]*MixnetServer, depth)
+	mc := &MixnetClientConfig{PubKeys: make([][32]byte, depth), MessageLength: messageLength}
+	for i := range servers {
+		masterKey := fmt.Sprintf("key%d", i)
+		servers[i] = NewMixnetServer(msc, i, masterKey)
+		mc.PubKeys[i] = PubKey(masterKey)
+	}
+	entry := httptest.NewServer(http.HandlerFunc(servers[depth-1].ServeReceive))
+	defer entry.Close()
+	mc.Addr = entry.URL
+	cl := NewMixnetClient(mc)
+
+	var hashes [][][32]byte
+	for i := 0; i < count; i++ {
+		msg := msgForId(i)
+		_, h, err := cl.SendMessageAudited(msg[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, h)
+	}
+
+	// push the batches down the chain, the way the push loops would
+	for i := depth - 1; i >= 0; i-- {
+		ms := servers[i]
+		onions := append([][]byte(nil), ms.onions.onions...)
+		b := ms.audit.commit(i, depth, onions)
+		if i > 0 {
+			if err := servers[i-1].Receive(&pb.PutOnionsRequest{Msgs: onions, AuditRoot: b.Root[:]}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		ms.audit.publish(b, onions)
+	}
+	// reveal the links of the odd nodes, the way revealLoop would
+	for i := 1; i < depth; i += 2 {
+		for _, b := range servers[i-1].audit.after(0) {
+			servers[i].audit.reveal(b)
+		}
+	}
+
+	chain := make([]NodeAudit, depth)
+	for i, ms := range servers {
+		chain[i] = NodeAudit{PubKey: mc.PubKeys[i], Batches: ms.audit.after(0)}
+	}
+	return chain, hashes
+}
+
+func TestCheckChain(t *testing.T) {
+	const depth = 4
+	chain, hashes := auditedChain(t, depth, 40)
+	if r := CheckChain(chain, afterDeadline); len(r.Errors) != 0 || r.Pending != 0 {
+		t.Fatalf("honest chain has errors %v and %d pending onions", r.Errors, r.Pending)
+	}
+	for _, h := range hashes {
+		if n := CheckOnion(chain, h); n != depth {
+			t.Errorf("onion went through %d nodes, want %d", n, depth)
+		}
+	}
+
+	// with randomized partial checking, about half of the links are revealed
+	for i, node := range chain {
+		if n := len(node.Batches[0].Links); n == 0 || n == 40 {
+			t.Errorf("node %d reveals %d of 40 links", i, n)
+		}
+	}
+}
+
+func TestCheckChainTampered(t *testing.T) {
+	const depth = 2
+	for _, tc := range []struct {
+		name   string
+		tamper func(b *BatchAudit)
+	}{
+		{"dropped", func(b *BatchAudit) {
+			b.Outputs = b.Outputs[1:]
+		}},
+		{"duplicated", func(b *BatchAudit) {
+			b.Inputs = append(b.Inputs, b.Inputs[0])
+			b.Outputs = append(b.Outputs, b.Outputs[0])
+			sortHashes(b.Inputs)
+			sortHashes(b.Outputs)
+		}},
+		{"invalid link", func(b *BatchAudit) {
+			b.Links[0].Key[0] ^= 1
+		}},
+		{"hidden link", func(b *BatchAudit) {
+			b.Links = b.Links[1:]
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			chain, _ := auditedChain(t, depth, 20)
+			b := chain[1].Batches[0]
+			tc.tamper(b)
+			b.Root = auditRoot(b.Inputs, b.Outputs)
+			if r := CheckChain(chain, afterDeadline); len(r.Errors) == 0 {
+				t.Errorf("tampered chain has no errors")
+			}
+		})
+	}
+
+	chain, hashes := auditedChain(t, depth, 20)
+	chain[0].Batches = nil
+	if n := CheckOnion(chain, hashes[0]); n != 1 {
+		t.Errorf("onion went through %d nodes, want 1", n)
+	}
+	// onions are pending until the deadline
+	if r := CheckChain(chain, time.Unix(0, 0)); len(r.Errors) != 0 || r.Pending != 20 {
+		t.Errorf("%d onions pending (errors %v), want 20", r.Pending, r.Errors)
+	}
+	if r := CheckChain(chain, afterDeadline); len(r.Errors) != 20 || r.Pending != 0 {
+		t.Errorf("%d onions pending and %d errors after the deadline, want 0 and 20", r.Pending, len(r.Errors))
+	}
+
+	// so are links which the odd node has not revealed yet
+	chain, _ = auditedChain(t, depth, 20)
+	chain[1].Batches[0].Links = nil
+	if r := CheckChain(chain, time.Unix(0, 0)); len(r.Errors) != 0 || r.Pending == 0 {
+		t.Errorf("%d onions pending (errors %v) before the links are revealed", r.Pending, r.Errors)
+	}
+	if r := CheckChain(chain, afterDeadline); len(r.Errors) == 0 {
+		t.Errorf("unrevealed links are not errors after the deadline")
+	}
+}
@@ -0,0 +1,95 @@
+package mixnet
+
+import (
+	cryptorand "crypto/rand"
+	"fmt"
+	"github.com/yunwilliamyu/contact-trace-mixnet/rand"
+	mathrand "math/rand"
+)
+
+// Cascade is one of several independent chains, so that one slow or
+// malicious chain does not stall or observe every client. The last nodes of
+// all cascades must push to the same store.
+type Cascade struct {
+	Addr       string
+	PubKeys    [][32]byte // reverse indexed!
+	Capacity   int        `json:",omitempty"`
+	EntryAddrs []string   `json:",omitempty"`
+}
+
+// cascades returns the cascades of the config, which is a single one if
+// Cascades is not set.
+func (c *MixnetClientConfig) cascades() []Cascade {
+	if len(c.Cascades) == 0 {
+		return []Cascade{{Addr: c.Addr, PubKeys: c.PubKeys, EntryAddrs: c.EntryAddrs}}
+	}
+	return c.Cascades
+}
+
+// entryAddrs returns the replicas of the entry node of the cascade.
+func (c Cascade) entryAddrs() []string {
+	if len(c.EntryAddrs) > 0 {
+		return c.EntryAddrs
+	}
+	return []string{c.Addr}
+}
+
+// ChooseCascade returns the index of a random cascade, weighted by capacity
+// if the config says so.
+func (mc *MixnetClient) ChooseCascade() int {
+	cascades := mc.conf.cascades()
+	rng := mathrand.New(rand.ReaderSource{Reader: cryptorand.Reader})
+	if mc.conf.Weighted {
+		total := 0
+		for _, c := range cascades {
+			if c.Capacity > 0 {
+				total += c.Capacity
+			}
+		}
+		// without any advertised capacity, fall back to uniform
+		if total > 0 {
+			r := rng.Intn(total)
+			for i, c := range cascades {
+				if c.Capacity <= 0 {
+					continue
+				}
+				if r < c.Capacity {
+					return i
+				}
+				r -= c.Capacity
+			}
+		}
+	}
+	return rng.Intn(len(cascades))
+}
+
+// MakeCascadesClientConfig fetches the keys of the nodes of several chains.
+// They must use the same message length, and push to the same store.
+func MakeCascadesClientConfig(scs []*MixnetServerConfig, weighted bool) (*MixnetClientConfig, error) {
+	if len(scs) == 0 {
+		return nil, fmt.Errorf("no cascades")
+	}
+	conf := &MixnetClientConfig{
+		MessageLength: scs[0].MessageLength,
+		Weighted:      weighted,
+		Receipts:      scs[0].Receipts,
+	}
+	for i, sc := range scs {
+		if sc.MessageLength != conf.MessageLength {
+			return nil, fmt.Errorf("cascade %d has message length %d instead of %d", i, sc.MessageLength, conf.MessageLength)
+		}
+		if sc.OutputAddr != scs[0].OutputAddr {
+			return nil, fmt.Errorf("cascade %d pushes to %q instead of %q", i, sc.OutputAddr, scs[0].OutputAddr)
+		}
+		if sc.Receipts != conf.Receipts {
+			return nil, fmt.Errorf("cascade %d does not agree on receipts", i)
+		}
+		c, err := MakeClientConfig(sc)
+		if err != nil {
+			return nil, err
+		}
+		conf.ReceiptAddrs = append(conf.ReceiptAddrs, c.ReceiptAddrs...)
+		conf.Cascades = append(conf.Cascades, Cascade{Addr: c.Addr, PubKeys: c.PubKeys, Capacity: sc.Capacity, EntryAddrs: c.EntryAddrs})
+	}
+	return conf, nil
+}
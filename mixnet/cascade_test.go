@@ -0,0 +1,65 @@
+package mixnet
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestChooseCascade(t *testing.T) {
+	conf := &MixnetClientConfig{
+		Cascades: []Cascade{{Capacity: 0}, {Capacity: 1}, {Capacity: 3}},
+		Weighted: true,
+	}
+	mc := NewMixnetClient(conf)
+	const n = 4000
+	var counts [3]int
+	for i := 0; i < n; i++ {
+		counts[mc.ChooseCascade()]++
+	}
+	if counts[0] != 0 {
+		t.Errorf("cascade without capacity chosen %d times", counts[0])
+	}
+	if counts[2] < 2*counts[1] {
+		t.Errorf("cascades chosen %v times, want about 1:3", counts[1:])
+	}
+
+	conf.Weighted = false
+	counts = [3]int{}
+	for i := 0; i < n; i++ {
+		counts[mc.ChooseCascade()]++
+	}
+	for i, c := range counts {
+		if c < n/6 {
+			t.Errorf("cascade %d chosen %d of %d times with uniform choice", i, c, n)
+		}
+	}
+}
+
+func TestSendMessageCascades(t *testing.T) {
+	var mu sync.Mutex
+	received := make(map[int]int)
+	conf := &MixnetClientConfig{MessageLength: messageLength}
+	for i := 0; i < 2; i++ {
+		i := i
+		srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			mu.Lock()
+			received[i]++
+			mu.Unlock()
+			rw.WriteHeader(http.StatusAccepted)
+		}))
+		defer srv.Close()
+		conf.Cascades = append(conf.Cascades, Cascade{Addr: srv.URL, PubKeys: [][32]byte{PubKey("key")}})
+	}
+	mc := NewMixnetClient(conf)
+	for i := 0; i < 50; i++ {
+		msg := msgForId(i)
+		if err := mc.SendMessage(msg[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if received[0] == 0 || received[1] == 0 {
+		t.Errorf("cascades received %v messages, want both to receive some", received)
+	}
+}
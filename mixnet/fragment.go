@@ -0,0 +1,258 @@
+package mixnet
+
+import (
+	cryptorand "crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// Payloads of any size are padded with a 0x80 byte and as many zeros as
+// needed, and split into fragments:
+//
+//	id(8) + index(2) + count(2) + chunk
+//
+// All fragments of a payload share the random id, and the Reassembler puts
+// them back together once it has all count of them. The fragments are sealed
+// for the recipient before they are sent (e.g. in store envelopes, see
+// store.SendPayload), so that the mixnet cannot tell which messages belong
+// to the same payload.
+
+const FragmentHeaderSize = 12
+
+const maxFragments = 1<<16 - 1
+
+// Fragment pads payload and splits it into fragments of messageLength.
+func Fragment(payload []byte, messageLength int) ([][]byte, error) {
+	chunkSize := messageLength - FragmentHeaderSize
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("message length %d too short for fragments", messageLength)
+	}
+	count := len(payload)/chunkSize + 1 // there is always room for the padding
+	if count > maxFragments {
+		return nil, fmt.Errorf("payload of %d bytes needs too many fragments", len(payload))
+	}
+	padded := make([]byte, count*chunkSize)
+	copy(padded, payload)
+	padded[len(payload)] = 0x80
+
+	var id [8]byte
+	if _, err := io.ReadFull(cryptorand.Reader, id[:]); err != nil {
+		return nil, err
+	}
+	msgs := make([][]byte, count)
+	for i := range msgs {
+		msg := make([]byte, messageLength)
+		copy(msg, id[:])
+		binary.BigEndian.PutUint16(msg[8:], uint16(i))
+		binary.BigEndian.PutUint16(msg[10:], uint16(count))
+		copy(msg[FragmentHeaderSize:], padded[i*chunkSize:])
+		msgs[i] = msg
+	}
+	return msgs, nil
+}
+
+func unpad(padded []byte) ([]byte, error) {
+	for i := len(padded) - 1; i >= 0; i-- {
+		switch padded[i] {
+		case 0:
+		case 0x80:
+			return padded[:i], nil
+		default:
+			return nil, fmt.Errorf("invalid padding")
+		}
+	}
+	return nil, fmt.Errorf("invalid padding")
+}
+
+// SendPayload sends a payload of any size as one or several messages, all
+// through the same cascade. It is split into fragments of fragmentLength,
+// and seal turns each of them into a message of MessageLength for the
+// recipient, which needs a Reassembler.
+func (mc *MixnetClient) SendPayload(payload []byte, fragmentLength int, seal func(fragment []byte) ([]byte, error)) error {
+	fragments, err := Fragment(payload, fragmentLength)
+	if err != nil {
+		return err
+	}
+	cascade := mc.ChooseCascade()
+	for _, f := range fragments {
+		msg, err := seal(f)
+		if err != nil {
+			return err
+		}
+		if _, _, err := mc.sendVia(cascade, msg, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type partialPayload struct {
+	fragments map[int][]byte
+	count     int
+	firstSeen time.Time
+}
+
+const (
+	defaultMaxPayloadLength = 1 << 20
+	defaultMaxPartial       = 1000
+)
+
+// Reassembler collects fragments, and passes the payloads to Handler (or the
+// handler given to Collect) once they are complete.
+type Reassembler struct {
+	Handler func(payloads [][]byte) error
+	// MaxAge is how long to wait for the missing fragments of a payload,
+	// an hour if not set.
+	MaxAge time.Duration
+	// MaxPayloadLength is the longest payload accepted, 1 MiB if not set.
+	// Fragments of payloads which would need more fragments are dropped.
+	MaxPayloadLength int
+	// MaxPartial is how many incomplete payloads to keep, 1000 if not set.
+	// The oldest one is dropped to make room for another.
+	MaxPartial int
+
+	partial map[[8]byte]*partialPayload
+	done    map[[8]byte]time.Time // to ignore fragments pushed again
+	ready   [][]byte              // payloads not handled yet
+	mu      sync.Mutex
+
+	now func() time.Time // defaults to time.Now
+}
+
+func (r *Reassembler) timeNow() time.Time {
+	if r.now != nil {
+		return r.now()
+	}
+	return time.Now()
+}
+
+func (r *Reassembler) expire() {
+	maxAge := r.MaxAge
+	if maxAge == 0 {
+		maxAge = time.Hour
+	}
+	cutoff := r.timeNow().Add(-maxAge)
+	for id, p := range r.partial {
+		if p.firstSeen.Before(cutoff) {
+			log.Printf("dropping payload with %d of %d fragments", len(p.fragments), p.count)
+			delete(r.partial, id)
+		}
+	}
+	for id, t := range r.done {
+		if t.Before(cutoff) {
+			delete(r.done, id)
+		}
+	}
+}
+
+// makeRoom drops the oldest incomplete payload if there are MaxPartial.
+func (r *Reassembler) makeRoom() {
+	maxPartial := r.MaxPartial
+	if maxPartial == 0 {
+		maxPartial = defaultMaxPartial
+	}
+	if len(r.partial) < maxPartial {
+		return
+	}
+	var oldest [8]byte
+	var oldestSeen time.Time
+	for id, p := range r.partial {
+		if oldestSeen.IsZero() || p.firstSeen.Before(oldestSeen) {
+			oldest, oldestSeen = id, p.firstSeen
+		}
+	}
+	p := r.partial[oldest]
+	log.Printf("dropping payload with %d of %d fragments", len(p.fragments), p.count)
+	delete(r.partial, oldest)
+}
+
+func (r *Reassembler) add(msg []byte) error {
+	if len(msg) <= FragmentHeaderSize {
+		return fmt.Errorf("fragment too short")
+	}
+	var id [8]byte
+	copy(id[:], msg)
+	index := int(binary.BigEndian.Uint16(msg[8:]))
+	count := int(binary.BigEndian.Uint16(msg[10:]))
+	if index >= count {
+		return fmt.Errorf("fragment %d of %d", index, count)
+	}
+	maxPayloadLength := r.MaxPayloadLength
+	if maxPayloadLength == 0 {
+		maxPayloadLength = defaultMaxPayloadLength
+	}
+	// the same bound as in Fragment
+	if count > maxPayloadLength/(len(msg)-FragmentHeaderSize)+1 {
+		return fmt.Errorf("payload with %d fragments is too long", count)
+	}
+	if _, ok := r.done[id]; ok {
+		return nil
+	}
+	p, ok := r.partial[id]
+	if !ok {
+		r.makeRoom()
+		p = &partialPayload{fragments: make(map[int][]byte), count: count, firstSeen: r.timeNow()}
+		r.partial[id] = p
+	}
+	if p.count != count {
+		return fmt.Errorf("fragment %d of %d of a payload with %d fragments", index, count, p.count)
+	}
+	if _, ok := p.fragments[index]; ok {
+		return nil
+	}
+	p.fragments[index] = append([]byte(nil), msg[FragmentHeaderSize:]...)
+	if len(p.fragments) < count {
+		return nil
+	}
+
+	delete(r.partial, id)
+	r.done[id] = p.firstSeen
+	var padded []byte
+	for i := 0; i < count; i++ {
+		padded = append(padded, p.fragments[i]...)
+	}
+	payload, err := unpad(padded)
+	if err != nil {
+		return err
+	}
+	r.ready = append(r.ready, payload)
+	return nil
+}
+
+// Push adds a batch of fragments, and passes the payloads they complete to
+// Handler.
+func (r *Reassembler) Push(msgs [][]byte) error {
+	return r.Collect(msgs, func(payloads [][]byte) error {
+		if len(payloads) == 0 {
+			return nil
+		}
+		return r.Handler(payloads)
+	})
+}
+
+// Collect adds a batch of fragments, and passes the payloads they complete,
+// if any, to handle. If handle fails, the payloads are passed again with the
+// next batch.
+func (r *Reassembler) Collect(msgs [][]byte, handle func(payloads [][]byte) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.partial == nil {
+		r.partial = make(map[[8]byte]*partialPayload)
+		r.done = make(map[[8]byte]time.Time)
+	}
+	r.expire()
+	for _, msg := range msgs {
+		if err := r.add(msg); err != nil {
+			log.Printf("received invalid fragment: %s", err.Error())
+		}
+	}
+	if err := handle(r.ready); err != nil {
+		return err
+	}
+	r.ready = nil
+	return nil
+}
@@ -0,0 +1,199 @@
+package mixnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFragment(t *testing.T) {
+	const msgLength = 32
+	const chunkSize = msgLength - FragmentHeaderSize
+	var payloads [][]byte
+	for _, n := range []int{0, 1, chunkSize - 1, chunkSize, 3*chunkSize + 5} {
+		payloads = append(payloads, bytes.Repeat([]byte{byte(n)}, n))
+	}
+	payloads = append(payloads, []byte{1, 0x80, 0, 0})
+
+	var got [][]byte
+	r := &Reassembler{Handler: func(p [][]byte) error {
+		got = append(got, p...)
+		return nil
+	}}
+	var last [][]byte
+	for _, p := range payloads {
+		msgs, err := Fragment(p, msgLength)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msgs) != len(p)/chunkSize+1 {
+			t.Errorf("%d byte payload split into %d fragments", len(p), len(msgs))
+		}
+		// fragments arrive in any order, and in several batches
+		for i := len(msgs) - 1; i >= 0; i-- {
+			if len(msgs[i]) != msgLength {
+				t.Fatalf("fragment is %d bytes long", len(msgs[i]))
+			}
+			if err := r.Push(append(last, msgs[i])); err != nil {
+				t.Fatal(err)
+			}
+			last = [][]byte{msgs[i]} // pushed again, which is ignored
+		}
+	}
+	if len(got) != len(payloads) {
+		t.Fatalf("reassembled %d payloads, want %d", len(got), len(payloads))
+	}
+	for i := range got {
+		if !bytes.Equal(got[i], payloads[i]) {
+			t.Errorf("reassembled %v, want %v", got[i], payloads[i])
+		}
+	}
+
+	if _, err := Fragment(nil, FragmentHeaderSize); err == nil {
+		t.Errorf("Fragment accepted a message length without room for the payload")
+	}
+}
+
+func TestReassemblerRetry(t *testing.T) {
+	fail := true
+	var got [][]byte
+	r := &Reassembler{Handler: func(p [][]byte) error {
+		if fail {
+			return fmt.Errorf("down")
+		}
+		got = append(got, p...)
+		return nil
+	}}
+	msgs, err := Fragment([]byte("payload"), 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Push(msgs); err == nil {
+		t.Fatal("Push did not return the error of Handler")
+	}
+	// the batch is pushed again, but the payload is only handled once
+	fail = false
+	if err := r.Push(msgs); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || string(got[0]) != "payload" {
+		t.Errorf("handled %q, want [payload]", got)
+	}
+}
+
+func TestReassemblerExpiry(t *testing.T) {
+	now := time.Unix(1000, 0)
+	var got [][]byte
+	r := &Reassembler{
+		Handler: func(p [][]byte) error {
+			got = append(got, p...)
+			return nil
+		},
+		MaxAge: time.Minute,
+		now:    func() time.Time { return now },
+	}
+	msgs, err := Fragment(bytes.Repeat([]byte{1}, 20), 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Push(msgs[:1]); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(2 * time.Minute)
+	if err := r.Push(msgs[1:]); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("reassembled a payload from an expired fragment")
+	}
+}
+
+func TestReassemblerLimits(t *testing.T) {
+	now := time.Unix(1000, 0)
+	var got []string
+	r := &Reassembler{
+		Handler: func(p [][]byte) error {
+			for _, payload := range p {
+				got = append(got, string(payload))
+			}
+			return nil
+		},
+		MaxPayloadLength: 20,
+		MaxPartial:       2,
+		now:              func() time.Time { return now },
+	}
+	fragment := func(payload string) [][]byte {
+		msgs, err := Fragment([]byte(payload), 20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msgs
+	}
+	// fragments claiming more than MaxPayloadLength are dropped
+	tooLong := fragment(strings.Repeat("x", 30))
+	forged := append([]byte(nil), fragment("forged")[0]...)
+	binary.BigEndian.PutUint16(forged[10:], maxFragments)
+	if err := r.Push(append(tooLong, forged)); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 || len(r.partial) != 0 {
+		t.Fatalf("kept %d partial payloads and handled %q", len(r.partial), got)
+	}
+
+	// the oldest incomplete payload makes room for a new one
+	var payloads [][][]byte
+	for _, p := range []string{"first payload", "second payload", "third payload"} {
+		msgs := fragment(p)
+		payloads = append(payloads, msgs)
+		if err := r.Push(msgs[:1]); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(time.Second)
+	}
+	for i := len(payloads) - 1; i >= 0; i-- {
+		if err := r.Push(payloads[i][1:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fmt.Sprint(got) != "[third payload second payload]" {
+		t.Errorf("handled %q, want the second and third payload", got)
+	}
+}
+
+func TestSendPayloadCascade(t *testing.T) {
+	const msgLength = 32
+	var mu sync.Mutex
+	received := make(map[int]int)
+	conf := &MixnetClientConfig{MessageLength: msgLength}
+	for i := 0; i < 2; i++ {
+		i := i
+		srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			mu.Lock()
+			received[i]++
+			mu.Unlock()
+			rw.WriteHeader(http.StatusAccepted)
+		}))
+		defer srv.Close()
+		conf.Cascades = append(conf.Cascades, Cascade{Addr: srv.URL, PubKeys: [][32]byte{PubKey("key")}})
+	}
+	mc := NewMixnetClient(conf)
+	seal := func(fragment []byte) ([]byte, error) {
+		// a real recipient would encrypt the fragment
+		return fragment, nil
+	}
+	for i := 0; i < 10; i++ {
+		received = make(map[int]int)
+		if err := mc.SendPayload(bytes.Repeat([]byte{1}, 5*msgLength), msgLength, seal); err != nil {
+			t.Fatal(err)
+		}
+		if len(received) != 1 {
+			t.Fatalf("fragments sent through cascades %v, want one", received)
+		}
+	}
+}
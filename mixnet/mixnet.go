@@ -27,6 +27,18 @@ type MixnetClientConfig struct {
 	Addr          string
 	PubKeys       [][32]byte // reverse indexed!
 	MessageLength int
+
+	// Cascades are used instead of Addr and PubKeys if set, see cascade.go.
+	Cascades []Cascade `json:",omitempty"`
+	// Weighted chooses cascades in proportion to their Capacity instead of
+	// uniformly.
+	Weighted bool `json:",omitempty"`
+	// EntryAddrs are replicas of the entry node, used instead of Addr if set.
+	EntryAddrs []string `json:",omitempty"`
+	// Receipts is set if messages carry receipt tokens, which are published
+	// by the ReceiptAddrs, see receipts.go.
+	Receipts     bool     `json:",omitempty"`
+	ReceiptAddrs []string `json:",omitempty"`
 }
 
 type MixnetServerConfig struct {
@@ -36,13 +48,26 @@ type MixnetServerConfig struct {
 	MinBatchSize        int `json:"min_batch_size"`
 	MessageLength       int `json:"message_length"`
 	MaxBufferedMessages int `json:"max_buffered_messages"`
+	// Capacity is advertised to clients choosing between cascades.
+	Capacity int `json:"capacity,omitempty"`
+	// Replicas[idx] lists the replicas of the node with idx, which share its
+	// master key. Addrs[idx] is the only one if it is not set, see replicas.go.
+	Replicas [][]string `json:"replicas,omitempty"`
+	// Audit makes nodes publish commitments to their batches, see audit.go.
+	Audit bool `json:"audit,omitempty"`
+	// Receipts makes the node with idx 0 publish receipts, see receipts.go.
+	Receipts bool `json:"receipts,omitempty"`
 }
 
+// NextAddr returns the address of the first replica of the next node.
 func (msc MixnetServerConfig) NextAddr(idx int) string {
-	return msc.Addrs[idx-1]
+	return msc.PositionAddrs(idx - 1)[0]
 }
 
 func (msc MixnetServerConfig) InputMessageLength(idx int) int {
+	if msc.Receipts {
+		return ForwardMessageLength(idx, msc.MessageLength+ReceiptTokenSize)
+	}
 	return ForwardMessageLength(idx, msc.MessageLength)
 }
 
@@ -74,13 +99,52 @@ type MixnetServer struct {
 	keys        keys
 	otpChecker  *OTPChecker
 	PushHandler func([][]byte) error
-	// next server address/connection to it
+	next        *balancer // replicas of the next node, if any
+
+	onions *queue // messages to forward, already decrypted
+	audit  *auditLog
+	// receipts of the batches pushed by the node with idx 0
+	receipts *receiptLog
+
+	// ReplyHandler receives the replies delivered by the first node of the
+	// chain, see reply.go.
+	ReplyHandler func([][]byte) error
+	replies      *queue
+	prev         *balancer // replicas of the previous node, if any
+	// expiry of the reply blocks seen, by the hash of their key
+	seenReplies map[[32]byte]int64
+	nextReap    time.Time
+	seenMu      sync.Mutex
+
+	now func() time.Time // defaults to time.Now
+}
+
+func (ms *MixnetServer) timeNow() time.Time {
+	if ms.now != nil {
+		return ms.now()
+	}
+	return time.Now()
+}
 
-	onions      [][]byte // messages to forward, already decrypted
+// queue buffers processed onions until there are enough for a batch.
+type queue struct {
+	onions      [][]byte
 	mu          sync.Mutex
 	readyToPush *sync.Cond
 }
 
+func newQueue() *queue {
+	q := &queue{}
+	q.readyToPush = sync.NewCond(&q.mu)
+	return q
+}
+
+func (q *queue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.onions)
+}
+
 func (ms *MixnetServer) checkOTP(req *pb.PutOnionsRequest) error {
 	if ms.otpChecker == nil {
 		return nil
@@ -94,10 +158,8 @@ func (ms *MixnetServer) checkOTP(req *pb.PutOnionsRequest) error {
 
 func (ms *MixnetServer) Receive(req *pb.PutOnionsRequest) error {
 	// TODO: do we need to ensure that only the previous server is talking to us? probably, because
-	ms.mu.Lock()
 	// do not bother decrypting if we want to refuse anyway
-	messageCount := len(ms.onions) + len(req.Msgs)
-	ms.mu.Unlock()
+	messageCount := ms.onions.len() + len(req.Msgs)
 
 	if messageCount > ms.conf.MaxBufferedMessages {
 		return fmt.Errorf("too many buffered messages")
@@ -118,7 +180,13 @@ func (ms *MixnetServer) Receive(req *pb.PutOnionsRequest) error {
 			log.Printf("received invalid message: %s", err.Error())
 			continue
 		}
-		ms.addMessage(decMsg)
+		if ms.audit != nil {
+			if err := ms.audit.add(ms.keys, msg, decMsg, req.AuditRoot); err != nil {
+				log.Printf("not auditable: %s", err.Error())
+				continue
+			}
+		}
+		ms.addMessage(ms.onions, decMsg)
 	}
 	return nil
 }
@@ -141,6 +209,13 @@ func (ms *MixnetServer) ServeConfig(rw http.ResponseWriter, req *http.Request) {
 }
 
 func (ms *MixnetServer) ServeReceive(rw http.ResponseWriter, req *http.Request) {
+	ServeOnions(rw, req, ms.conf.InputMessageLength(ms.idx), ms.Receive)
+}
+
+// ServeOnions parses a batch of onions of msgLength, sent as raw
+// concatenated messages or as a json PutOnionsRequest, and passes it to
+// receive.
+func ServeOnions(rw http.ResponseWriter, req *http.Request, msgLength int, receive func(*pb.PutOnionsRequest) error) {
 	if req.Method != http.MethodPost {
 		http.Error(rw, "only POST allowed", http.StatusBadRequest)
 		return
@@ -148,9 +223,9 @@ func (ms *MixnetServer) ServeReceive(rw http.ResponseWriter, req *http.Request)
 	ct := req.Header.Get("Content-Type")
 	switch ct {
 	case "application/octet-stream":
-		ms.legacyReceive(rw, req)
+		legacyReceive(rw, req, msgLength, receive)
 	case "application/json":
-		ms.jsonReceive(rw, req)
+		jsonReceive(rw, req, receive)
 	default:
 		http.Error(rw, fmt.Sprintf("unknown request Content-Type %q", ct), http.StatusBadRequest)
 	}
@@ -158,7 +233,7 @@ func (ms *MixnetServer) ServeReceive(rw http.ResponseWriter, req *http.Request)
 
 //go:generate protoc pb/mixnet.proto --go_out=. --go_opt=paths=source_relative
 
-func (ms *MixnetServer) jsonReceive(rw http.ResponseWriter, req *http.Request) {
+func jsonReceive(rw http.ResponseWriter, req *http.Request, receive func(*pb.PutOnionsRequest) error) {
 	contents, err := ioutil.ReadAll(req.Body)
 	req.Body.Close()
 	if err != nil {
@@ -170,17 +245,17 @@ func (ms *MixnetServer) jsonReceive(rw http.ResponseWriter, req *http.Request) {
 		http.Error(rw, fmt.Sprintf("couldn't parse request: %s", err.Error()), http.StatusBadRequest)
 		return
 	}
-	if err := ms.Receive(putReq); err != nil {
+	if err := receive(putReq); err != nil {
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	rw.WriteHeader(http.StatusAccepted)
 }
 
-func (ms *MixnetServer) legacyReceive(rw http.ResponseWriter, req *http.Request) {
+func legacyReceive(rw http.ResponseWriter, req *http.Request, msgLength int, receive func(*pb.PutOnionsRequest) error) {
 	putReq := &pb.PutOnionsRequest{}
 	for {
-		msg := make([]byte, ms.conf.InputMessageLength(ms.idx))
+		msg := make([]byte, msgLength)
 		if _, err := io.ReadFull(req.Body, msg); err != nil {
 			if err == io.EOF {
 				break
@@ -190,22 +265,64 @@ func (ms *MixnetServer) legacyReceive(rw http.ResponseWriter, req *http.Request)
 		}
 		putReq.Msgs = append(putReq.Msgs, msg)
 	}
-	if err := ms.Receive(putReq); err != nil {
+	if err := receive(putReq); err != nil {
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	rw.WriteHeader(http.StatusAccepted)
 }
 
-func (ms *MixnetServer) push(onions [][]byte) error {
+// pushOnions pushes a batch, and publishes its audit once it was pushed.
+func (ms *MixnetServer) pushOnions(onions [][]byte) error {
+	var b *BatchAudit
+	var root []byte
+	if ms.audit != nil {
+		b = ms.audit.commit(ms.idx, len(ms.conf.Addrs), onions)
+		root = b.Root[:]
+	}
+	msgs := onions
+	var tokens [][32]byte
+	if ms.receipts != nil {
+		msgs, tokens = stripTokens(onions)
+	}
+	var err error
+	if ms.PushHandler != nil {
+		err = ms.PushHandler(msgs)
+	} else {
+		err = ms.push(msgs, root)
+	}
+	if err != nil {
+		return err
+	}
+	if b != nil {
+		ms.audit.publish(b, onions)
+	}
+	if ms.receipts != nil {
+		ms.receipts.publish(tokens)
+	}
+	return nil
+}
+
+func (ms *MixnetServer) push(onions [][]byte, auditRoot []byte) error {
+	if ms.idx > 0 {
+		return ms.next.do(func(addr string) error {
+			return pushTo(sendURL(addr), onions, auditRoot)
+		})
+	}
+	return pushTo(sendURL(ms.conf.OutputAddr), onions, auditRoot)
+}
+
+// pushTo shuffles the onions and posts them to url.
+func pushTo(url string, onions [][]byte, auditRoot []byte) error {
 	// TODO: this reads urandom. make this read csprng
-	rng := mathrand.New(rand.ReaderSource{cryptorand.Reader})
+	rng := mathrand.New(rand.ReaderSource{Reader: cryptorand.Reader})
 	rng.Shuffle(len(onions), func(i, j int) {
 		onions[i], onions[j] = onions[j], onions[i]
 	})
 
 	req := &pb.PutOnionsRequest{
-		Msgs: onions,
+		Msgs:      onions,
+		AuditRoot: auditRoot,
 	}
 
 	rawReq, err := protojson.Marshal(req)
@@ -215,48 +332,37 @@ func (ms *MixnetServer) push(onions [][]byte) error {
 
 	// send to next
 	// TODO: cache clients/connections/something
-	var url string
-	if ms.idx > 0 {
-		url = sendURL(ms.conf.NextAddr(ms.idx))
-	} else {
-		url = sendURL(ms.conf.OutputAddr)
-	}
 	resp, err := http.Post(url, "application/json", bytes.NewReader(rawReq))
-	defer resp.Body.Close()
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode >= 400 {
-		return fmt.Errorf("status %d (%s) from /receive", resp.StatusCode, resp.Status)
+		return fmt.Errorf("status %d (%s) from %s", resp.StatusCode, resp.Status, url)
 	}
 	return nil
 }
 
-func (ms *MixnetServer) loop() {
+func (ms *MixnetServer) loop(q *queue, push func([][]byte) error) {
 	for {
 		var toSend [][]byte
-		ms.mu.Lock()
-		for len(ms.onions) < ms.conf.MinBatchSize {
-			ms.readyToPush.Wait()
+		q.mu.Lock()
+		for len(q.onions) < ms.conf.MinBatchSize {
+			q.readyToPush.Wait()
 		}
 		// we want some limit, but probably a larger one
-		toSend = ms.onions[:ms.conf.MinBatchSize]
-		ms.mu.Unlock()
+		toSend = q.onions[:ms.conf.MinBatchSize]
+		q.mu.Unlock()
 
 		toSend = append([][]byte(nil), toSend...) // we want to be able to write to toSend
 
 		log.Printf("pushing %d onions", len(toSend))
-		var err error
-		if ms.PushHandler != nil {
-			err = ms.PushHandler(toSend)
-		} else {
-			err = ms.push(toSend)
-		}
+		err := push(toSend)
 		if err == nil {
 			log.Printf("push successful")
-			ms.mu.Lock()
-			ms.onions = ms.onions[len(toSend):]
-			ms.mu.Unlock()
+			q.mu.Lock()
+			q.onions = q.onions[len(toSend):]
+			q.mu.Unlock()
 		} else {
 			log.Printf("error while pushing: %s", err.Error())
 			// TODO: reasonable backoffs for retrying
@@ -266,22 +372,29 @@ func (ms *MixnetServer) loop() {
 	}
 }
 
-func (ms *MixnetServer) addMessage(msg []byte) {
-	ms.mu.Lock()
-	ms.onions = append(ms.onions, msg)
-	if len(ms.onions) >= ms.conf.MinBatchSize {
-		ms.readyToPush.Signal()
+func (ms *MixnetServer) addMessage(q *queue, msg []byte) {
+	q.mu.Lock()
+	q.onions = append(q.onions, msg)
+	if len(q.onions) >= ms.conf.MinBatchSize {
+		q.readyToPush.Signal()
 	}
-	ms.mu.Unlock()
+	q.mu.Unlock()
 }
 
 func (ms *MixnetServer) Run(listenAddr string) error {
-	go ms.loop()
+	go ms.loop(ms.onions, ms.pushOnions)
+	go ms.loop(ms.replies, ms.pushReplies)
+	if ms.audit != nil && ms.idx%2 == 1 {
+		go ms.revealLoop()
+	}
 
 	mux := http.NewServeMux()
 	mux.Handle("/v0/receive", http.HandlerFunc(ms.ServeReceive))
+	mux.Handle("/v0/reply", http.HandlerFunc(ms.ServeReply))
 	mux.Handle("/v0/pubkey", http.HandlerFunc(ms.ServePubkey))
 	mux.Handle("/v0/config", http.HandlerFunc(ms.ServeConfig))
+	mux.Handle("/v0/audit", http.HandlerFunc(ms.ServeAudit))
+	mux.Handle("/v0/receipts", http.HandlerFunc(ms.ServeReceipts))
 
 	s := &http.Server{
 		Addr:    listenAddr,
@@ -306,7 +419,21 @@ func deriveKeys(masterKey string) keys {
 func NewMixnetServer(conf *MixnetServerConfig, idx int, masterKey string) *MixnetServer {
 	ms := &MixnetServer{conf: conf, idx: idx}
 	ms.keys = deriveKeys(masterKey)
-	ms.readyToPush = sync.NewCond(&ms.mu)
+	ms.onions = newQueue()
+	ms.replies = newQueue()
+	ms.seenReplies = make(map[[32]byte]int64)
+	if conf.Audit {
+		ms.audit = newAuditLog()
+	}
+	if conf.Receipts && idx == 0 {
+		ms.receipts = newReceiptLog()
+	}
+	if idx > 0 {
+		ms.next = newBalancer(conf.PositionAddrs(idx - 1))
+	}
+	if idx < len(conf.Addrs)-1 {
+		ms.prev = newBalancer(conf.PositionAddrs(idx + 1))
+	}
 	if conf.OtpCheck != "" && idx == len(conf.Addrs)-1 {
 		ms.otpChecker = NewOTPChecker(conf.OtpCheck)
 	}
@@ -319,62 +446,126 @@ func PubKey(masterKey string) [32]byte {
 }
 
 type MixnetClient struct {
-	conf *MixnetClientConfig
+	conf    *MixnetClientConfig
+	entries []*balancer // replicas of the entry node of each cascade
 }
 
 func NewMixnetClient(conf *MixnetClientConfig) *MixnetClient {
-	return &MixnetClient{conf: conf}
+	mc := &MixnetClient{conf: conf}
+	for _, c := range conf.cascades() {
+		mc.entries = append(mc.entries, newBalancer(c.entryAddrs()))
+	}
+	return mc
 }
 
+// SendMessage sends msg through a cascade chosen with ChooseCascade.
 func (mc *MixnetClient) SendMessage(msg []byte) error {
+	_, _, err := mc.SendMessageAudited(msg)
+	return err
+}
+
+// SendMessageAudited is SendMessage, but also returns the cascade it used and
+// the hashes of the onion received by each of its nodes, indexed like
+// PubKeys, for CheckOnion.
+func (mc *MixnetClient) SendMessageAudited(msg []byte) (int, [][32]byte, error) {
+	return mc.sendMessage(msg, nil)
+}
+
+// sendMessage sends msg with the receipt token, or a random one if token is
+// nil and the mixnet publishes receipts.
+func (mc *MixnetClient) sendMessage(msg []byte, token []byte) (int, [][32]byte, error) {
+	return mc.sendVia(mc.ChooseCascade(), msg, token)
+}
+
+// sendVia is sendMessage through the cascade with index i.
+func (mc *MixnetClient) sendVia(i int, msg []byte, token []byte) (int, [][32]byte, error) {
 	if len(msg) != mc.conf.MessageLength {
-		return fmt.Errorf("wrong message size: %d!=%d", len(msg), mc.conf.MessageLength)
+		return 0, nil, fmt.Errorf("wrong message size: %d!=%d", len(msg), mc.conf.MessageLength)
 	}
+	cascade := mc.conf.cascades()[i]
 	onion := msg
-	for _, pk := range mc.conf.PubKeys {
+	if mc.conf.Receipts {
+		if token == nil {
+			var err error
+			token, err = newReceiptToken()
+			if err != nil {
+				return 0, nil, err
+			}
+		}
+		onion = append(append([]byte(nil), token...), msg...)
+	}
+	hashes := make([][32]byte, len(cascade.PubKeys))
+	for j, pk := range cascade.PubKeys {
 		var err error
 		// TODO: decrease allocations: every second Seal can use the same output buffer
 		onion, err = box.SealAnonymous(nil, onion, &pk, cryptorand.Reader)
 		if err != nil {
-			return err
+			return 0, nil, err
 		}
+		hashes[j] = sha256.Sum256(onion)
 	}
-	resp, err := http.Post(sendURL(mc.conf.Addr), "application/octet-stream", bytes.NewReader(onion))
-	resp.Body.Close()
-	if err != nil {
-		return err
-	}
-	if resp.StatusCode > 400 {
-		return fmt.Errorf("status %d (%s) from /receive", resp.StatusCode, resp.Status)
-	}
-	return nil
+	return i, hashes, mc.entries[i].do(func(addr string) error {
+		resp, err := http.Post(sendURL(addr), "application/octet-stream", bytes.NewReader(onion))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode > 400 {
+			return fmt.Errorf("status %d (%s) from /receive", resp.StatusCode, resp.Status)
+		}
+		return nil
+	})
 }
 
+// MakeClientConfig fetches the keys of the nodes of the chain described by
+// sc. See MakeCascadesClientConfig for several chains.
 func MakeClientConfig(sc *MixnetServerConfig) (*MixnetClientConfig, error) {
 	conf := &MixnetClientConfig{
 		Addr:          sc.Addrs[len(sc.Addrs)-1],
 		PubKeys:       make([][32]byte, len(sc.Addrs)),
 		MessageLength: sc.MessageLength,
 	}
+	if entries := sc.PositionAddrs(len(sc.Addrs) - 1); len(entries) > 1 {
+		conf.EntryAddrs = entries
+	}
+	if sc.Receipts {
+		conf.Receipts = true
+		conf.ReceiptAddrs = sc.PositionAddrs(0)
+	}
 	// TODO: do in parallel
-	for i, addr := range sc.Addrs {
-		resp, err := http.Get(fmt.Sprintf("%s/v0/pubkey", addr))
-		if err != nil {
-			return nil, err
-		}
-		if resp.StatusCode != http.StatusOK {
-			resp.Body.Close()
-			return nil, fmt.Errorf("received %d (%s) from %s", resp.StatusCode, resp.Status, addr)
-		}
-		pubkey, err := ioutil.ReadAll(resp.Body)
-		resp.Body.Close()
-		if err != nil {
-			return nil, err
-		}
-		if len(pubkey) != 32 {
-			return nil, fmt.Errorf("key received from %s is %d bytes long instead of %d", addr, len(pubkey), 32)
+	for i := range sc.Addrs {
+		for j, addr := range sc.PositionAddrs(i) {
+			pubkey, err := fetchPubKey(addr)
+			if err != nil {
+				return nil, err
+			}
+			// replicas share the master key of their node
+			if j > 0 && pubkey != conf.PubKeys[i] {
+				return nil, fmt.Errorf("replica %s has a different key than %s", addr, sc.PositionAddrs(i)[0])
+			}
+			conf.PubKeys[i] = pubkey
 		}
-		copy(conf.PubKeys[i][:], pubkey)
 	}
 	return conf, nil
 }
+
+func fetchPubKey(addr string) ([32]byte, error) {
+	var key [32]byte
+	resp, err := http.Get(fmt.Sprintf("%s/v0/pubkey", addr))
+	if err != nil {
+		return key, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return key, fmt.Errorf("received %d (%s) from %s", resp.StatusCode, resp.Status, addr)
+	}
+	pubkey, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return key, err
+	}
+	if len(pubkey) != 32 {
+		return key, fmt.Errorf("key received from %s is %d bytes long instead of %d", addr, len(pubkey), 32)
+	}
+	copy(key[:], pubkey)
+	return key, nil
+}
@@ -33,6 +33,8 @@ type PutOnionsRequest struct {
 	Msgs [][]byte `protobuf:"bytes,1,rep,name=msgs,proto3" json:"msgs,omitempty"`
 	Otp  string   `protobuf:"bytes,2,opt,name=otp,proto3" json:"otp,omitempty"`
 	Cxid string   `protobuf:"bytes,3,opt,name=cxid,proto3" json:"cxid,omitempty"`
+	// root of the BatchAudit of the batch, if the pushing node audits
+	AuditRoot []byte `protobuf:"bytes,4,opt,name=audit_root,json=auditRoot,proto3" json:"audit_root,omitempty"`
 }
 
 func (x *PutOnionsRequest) Reset() {
@@ -88,19 +90,90 @@ func (x *PutOnionsRequest) GetCxid() string {
 	return ""
 }
 
+func (x *PutOnionsRequest) GetAuditRoot() []byte {
+	if x != nil {
+		return x.AuditRoot
+	}
+	return nil
+}
+
+// Batch is a batch pushed by the final node, as written by the sinks in
+// mixnet/sink.
+type Batch struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// unix time in nanoseconds at which the batch was pushed
+	Timestamp int64    `protobuf:"varint,1,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	Msgs      [][]byte `protobuf:"bytes,2,rep,name=msgs,proto3" json:"msgs,omitempty"`
+}
+
+func (x *Batch) Reset() {
+	*x = Batch{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_pb_mixnet_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Batch) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Batch) ProtoMessage() {}
+
+func (x *Batch) ProtoReflect() protoreflect.Message {
+	mi := &file_pb_mixnet_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Batch.ProtoReflect.Descriptor instead.
+func (*Batch) Descriptor() ([]byte, []int) {
+	return file_pb_mixnet_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Batch) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+func (x *Batch) GetMsgs() [][]byte {
+	if x != nil {
+		return x.Msgs
+	}
+	return nil
+}
+
 var File_pb_mixnet_proto protoreflect.FileDescriptor
 
 var file_pb_mixnet_proto_rawDesc = []byte{
 	0x0a, 0x0f, 0x70, 0x62, 0x2f, 0x6d, 0x69, 0x78, 0x6e, 0x65, 0x74, 0x2e, 0x70, 0x72, 0x6f, 0x74,
-	0x6f, 0x12, 0x02, 0x70, 0x62, 0x22, 0x4c, 0x0a, 0x10, 0x50, 0x75, 0x74, 0x4f, 0x6e, 0x69, 0x6f,
+	0x6f, 0x12, 0x02, 0x70, 0x62, 0x22, 0x6b, 0x0a, 0x10, 0x50, 0x75, 0x74, 0x4f, 0x6e, 0x69, 0x6f,
 	0x6e, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x12, 0x0a, 0x04, 0x6d, 0x73, 0x67,
 	0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0c, 0x52, 0x04, 0x6d, 0x73, 0x67, 0x73, 0x12, 0x10, 0x0a,
 	0x03, 0x6f, 0x74, 0x70, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6f, 0x74, 0x70, 0x12,
 	0x12, 0x0a, 0x04, 0x63, 0x78, 0x69, 0x64, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x63,
-	0x78, 0x69, 0x64, 0x42, 0x31, 0x5a, 0x2f, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f,
-	0x6d, 0x2f, 0x79, 0x75, 0x6d, 0x77, 0x69, 0x6c, 0x6c, 0x69, 0x61, 0x6d, 0x79, 0x75, 0x2f, 0x63,
-	0x6f, 0x6e, 0x74, 0x61, 0x63, 0x74, 0x2d, 0x74, 0x72, 0x61, 0x63, 0x65, 0x2d, 0x6d, 0x69, 0x78,
-	0x6e, 0x65, 0x74, 0x2f, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x78, 0x69, 0x64, 0x12, 0x1d, 0x0a, 0x0a, 0x61, 0x75, 0x64, 0x69, 0x74, 0x5f, 0x72, 0x6f, 0x6f,
+	0x74, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x09, 0x61, 0x75, 0x64, 0x69, 0x74, 0x52, 0x6f,
+	0x6f, 0x74, 0x22, 0x39, 0x0a, 0x05, 0x42, 0x61, 0x74, 0x63, 0x68, 0x12, 0x1c, 0x0a, 0x09, 0x74,
+	0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x09,
+	0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x12, 0x12, 0x0a, 0x04, 0x6d, 0x73, 0x67,
+	0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0c, 0x52, 0x04, 0x6d, 0x73, 0x67, 0x73, 0x42, 0x31, 0x5a,
+	0x2f, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x79, 0x75, 0x6d, 0x77,
+	0x69, 0x6c, 0x6c, 0x69, 0x61, 0x6d, 0x79, 0x75, 0x2f, 0x63, 0x6f, 0x6e, 0x74, 0x61, 0x63, 0x74,
+	0x2d, 0x74, 0x72, 0x61, 0x63, 0x65, 0x2d, 0x6d, 0x69, 0x78, 0x6e, 0x65, 0x74, 0x2f, 0x70, 0x62,
+	0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -115,9 +188,10 @@ func file_pb_mixnet_proto_rawDescGZIP() []byte {
 	return file_pb_mixnet_proto_rawDescData
 }
 
-var file_pb_mixnet_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_pb_mixnet_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
 var file_pb_mixnet_proto_goTypes = []interface{}{
 	(*PutOnionsRequest)(nil), // 0: pb.PutOnionsRequest
+	(*Batch)(nil),            // 1: pb.Batch
 }
 var file_pb_mixnet_proto_depIdxs = []int32{
 	0, // [0:0] is the sub-list for method output_type
@@ -145,6 +219,18 @@ func file_pb_mixnet_proto_init() {
 				return nil
 			}
 		}
+		file_pb_mixnet_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Batch); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -152,7 +238,7 @@ func file_pb_mixnet_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_pb_mixnet_proto_rawDesc,
 			NumEnums:      0,
-			NumMessages:   1,
+			NumMessages:   2,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
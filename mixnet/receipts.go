@@ -0,0 +1,283 @@
+package mixnet
+
+import (
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// With Receipts set, every message carries a random token of
+// ReceiptTokenSize in its innermost layer. The node with idx 0 removes it,
+// and after pushing a batch, publishes the hashes of the tokens of the batch.
+// A client can then confirm that its message left the mixnet by fetching all
+// of them, without revealing which one it is looking for. Clients which do
+// not care use random tokens, so they look the same.
+
+const ReceiptTokenSize = 16
+
+// maxReceiptBatches is the number of batches whose receipts a node keeps.
+const maxReceiptBatches = 1000
+
+// ReceiptBatch lists the hashes of the tokens of a batch pushed by the node
+// with idx 0.
+type ReceiptBatch struct {
+	Seq uint64
+	// Epoch is the unix time in nanoseconds at which the node started. Seq
+	// starts again at 1 when it changes.
+	Epoch  int64
+	Time   int64 // unix time in nanoseconds at which the batch was pushed
+	Hashes [][32]byte
+}
+
+type receiptLog struct {
+	batches []*ReceiptBatch
+	seq     uint64
+	epoch   int64
+	mu      sync.Mutex
+}
+
+func newReceiptLog() *receiptLog {
+	return &receiptLog{epoch: time.Now().UnixNano()}
+}
+
+// stripTokens returns the messages without their tokens, and the hashes of
+// the tokens in random order.
+func stripTokens(onions [][]byte) ([][]byte, [][32]byte) {
+	msgs := make([][]byte, len(onions))
+	hashes := make([][32]byte, len(onions))
+	for i, onion := range onions {
+		hashes[i] = sha256.Sum256(onion[:ReceiptTokenSize])
+		msgs[i] = onion[ReceiptTokenSize:]
+	}
+	// do not leak the order of the batch
+	sortHashes(hashes)
+	return msgs, hashes
+}
+
+func (rl *receiptLog) publish(hashes [][32]byte) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.seq++
+	rl.batches = append(rl.batches, &ReceiptBatch{Seq: rl.seq, Epoch: rl.epoch, Time: time.Now().UnixNano(), Hashes: hashes})
+	if len(rl.batches) > maxReceiptBatches {
+		rl.batches = rl.batches[len(rl.batches)-maxReceiptBatches:]
+	}
+}
+
+func (rl *receiptLog) after(seq uint64) []*ReceiptBatch {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if seq > rl.seq {
+		// the caller has seen batches from before a restart
+		seq = 0
+	}
+	i := sort.Search(len(rl.batches), func(i int) bool {
+		return rl.batches[i].Seq > seq
+	})
+	return append([]*ReceiptBatch(nil), rl.batches[i:]...)
+}
+
+// ServeReceipts returns the receipts of the batches after the one with Seq
+// ?after= as a json list, or all of them if there is no such batch.
+func (ms *MixnetServer) ServeReceipts(rw http.ResponseWriter, req *http.Request) {
+	if ms.receipts == nil {
+		http.Error(rw, "receipts are disabled", http.StatusNotFound)
+		return
+	}
+	var after uint64
+	if a := req.URL.Query().Get("after"); a != "" {
+		var err error
+		after, err = strconv.ParseUint(a, 10, 64)
+		if err != nil {
+			http.Error(rw, "invalid after", http.StatusBadRequest)
+			return
+		}
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(ms.receipts.after(after)); err != nil {
+		log.Printf("error while writing receipts: %s", err.Error())
+	}
+}
+
+// FetchReceipts returns the receipts published by the node at addr after the
+// batch with Seq after.
+func FetchReceipts(addr string, after uint64) ([]*ReceiptBatch, error) {
+	resp, err := http.Get(fmt.Sprintf("%s/v0/receipts?after=%d", addr, after))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("received %d (%s) from %s", resp.StatusCode, resp.Status, addr)
+	}
+	var receipts []*ReceiptBatch
+	if err := json.NewDecoder(resp.Body).Decode(&receipts); err != nil {
+		return nil, err
+	}
+	return receipts, nil
+}
+
+func newReceiptToken() ([]byte, error) {
+	token := make([]byte, ReceiptTokenSize)
+	if _, err := io.ReadFull(cryptorand.Reader, token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+type pendingMessage struct {
+	msg       []byte
+	tokens    [][32]byte // hashes of the tokens of all copies sent
+	sentAt    time.Time
+	confirmed bool
+}
+
+// ReceiptTracker sends messages and resends them until their receipts show
+// up at one of the ReceiptAddrs of the config.
+type ReceiptTracker struct {
+	mc      *MixnetClient
+	Timeout time.Duration // resend messages without receipts after this long
+
+	pending map[[32]byte]*pendingMessage // by hash of each of their tokens
+	after   map[string]uint64            // last batch seen, by address
+	epochs  map[string]int64             // of the last batch seen, by address
+	mu      sync.Mutex
+
+	now func() time.Time // defaults to time.Now
+}
+
+func NewReceiptTracker(mc *MixnetClient, timeout time.Duration) (*ReceiptTracker, error) {
+	if !mc.conf.Receipts {
+		return nil, fmt.Errorf("the mixnet does not publish receipts")
+	}
+	return &ReceiptTracker{
+		mc:      mc,
+		Timeout: timeout,
+		pending: make(map[[32]byte]*pendingMessage),
+		after:   make(map[string]uint64),
+		epochs:  make(map[string]int64),
+	}, nil
+}
+
+func (rt *ReceiptTracker) timeNow() time.Time {
+	if rt.now != nil {
+		return rt.now()
+	}
+	return time.Now()
+}
+
+// Send sends msg, which is tracked until its receipt shows up.
+func (rt *ReceiptTracker) Send(msg []byte) error {
+	return rt.send(&pendingMessage{msg: msg})
+}
+
+// send sends a copy of p with a fresh token, so that copies cannot be linked
+// by their receipts.
+func (rt *ReceiptTracker) send(p *pendingMessage) error {
+	token, err := newReceiptToken()
+	if err != nil {
+		return err
+	}
+	if _, _, err := rt.mc.sendMessage(p.msg, token); err != nil {
+		return err
+	}
+	hash := sha256.Sum256(token)
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if p.confirmed {
+		return nil
+	}
+	p.tokens = append(p.tokens, hash)
+	p.sentAt = rt.timeNow()
+	rt.pending[hash] = p
+	return nil
+}
+
+// Pending returns the number of messages without receipts.
+func (rt *ReceiptTracker) Pending() int {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	messages := make(map[*pendingMessage]bool)
+	for _, p := range rt.pending {
+		messages[p] = true
+	}
+	return len(messages)
+}
+
+// fetch returns the receipts published by the node at addr since the last
+// poll, starting over if the node restarted.
+func (rt *ReceiptTracker) fetch(addr string) ([]*ReceiptBatch, error) {
+	rt.mu.Lock()
+	after, epoch := rt.after[addr], rt.epochs[addr]
+	rt.mu.Unlock()
+	batches, err := FetchReceipts(addr, after)
+	if err != nil || after == 0 || len(batches) == 0 || batches[0].Epoch == epoch {
+		return batches, err
+	}
+	return FetchReceipts(addr, 0)
+}
+
+// Poll fetches new receipts, and resends the messages whose receipts did not
+// show up within Timeout. It returns the number of messages confirmed.
+func (rt *ReceiptTracker) Poll() (int, error) {
+	confirmed := 0
+	for _, addr := range rt.mc.conf.ReceiptAddrs {
+		batches, err := rt.fetch(addr)
+		if err != nil {
+			return confirmed, err
+		}
+		rt.mu.Lock()
+		for _, b := range batches {
+			for _, h := range b.Hashes {
+				if p, ok := rt.pending[h]; ok {
+					// any copy confirms the message
+					for _, token := range p.tokens {
+						delete(rt.pending, token)
+					}
+					p.confirmed = true
+					confirmed++
+				}
+			}
+			rt.after[addr] = b.Seq
+			rt.epochs[addr] = b.Epoch
+		}
+		rt.mu.Unlock()
+	}
+
+	rt.mu.Lock()
+	resend := make(map[*pendingMessage]bool)
+	for _, p := range rt.pending {
+		if rt.timeNow().Sub(p.sentAt) >= rt.Timeout {
+			resend[p] = true
+		}
+	}
+	rt.mu.Unlock()
+	for p := range resend {
+		if err := rt.send(p); err != nil {
+			return confirmed, err
+		}
+	}
+	return confirmed, nil
+}
+
+// Run polls every interval.
+func (rt *ReceiptTracker) Run(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		confirmed, err := rt.Poll()
+		if err != nil {
+			log.Printf("error while polling receipts: %s", err.Error())
+		}
+		if confirmed > 0 {
+			log.Printf("%d messages delivered, %d pending", confirmed, rt.Pending())
+		}
+	}
+}
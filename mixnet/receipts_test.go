@@ -0,0 +1,130 @@
+package mixnet
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReceiptTracker(t *testing.T) {
+	msc := &MixnetServerConfig{
+		MinBatchSize:        1,
+		MessageLength:       messageLength,
+		MaxBufferedMessages: 1000,
+		Addrs:               make([]string, 1),
+		Receipts:            true,
+	}
+	var pushed [][]byte
+	newServer := func() *MixnetServer {
+		ms := NewMixnetServer(msc, 0, "key")
+		ms.PushHandler = func(msgs [][]byte) error {
+			pushed = append(pushed, msgs...)
+			return nil
+		}
+		return ms
+	}
+	ms := newServer()
+	mux := http.NewServeMux()
+	// ms is replaced to restart the node
+	mux.HandleFunc("/v0/receive", func(rw http.ResponseWriter, req *http.Request) {
+		ms.ServeReceive(rw, req)
+	})
+	mux.HandleFunc("/v0/receipts", func(rw http.ResponseWriter, req *http.Request) {
+		ms.ServeReceipts(rw, req)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	mc := NewMixnetClient(&MixnetClientConfig{
+		Addr:          srv.URL,
+		PubKeys:       [][32]byte{PubKey("key")},
+		MessageLength: messageLength,
+		Receipts:      true,
+		ReceiptAddrs:  []string{srv.URL},
+	})
+	now := time.Unix(1000, 0)
+	rt, err := NewReceiptTracker(mc, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		msg := msgForId(i)
+		if err := rt.Send(msg[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ms.pushOnions(ms.onions.onions); err != nil {
+		t.Fatal(err)
+	}
+	ms.onions.onions = nil
+	for i, msg := range pushed {
+		if len(msg) != messageLength {
+			t.Errorf("pushed message %d is %d bytes long, want %d", i, len(msg), messageLength)
+		}
+	}
+	if confirmed, err := rt.Poll(); err != nil || confirmed != 3 {
+		t.Fatalf("Poll confirmed %d messages (%v), want 3", confirmed, err)
+	}
+
+	// a message which got lost is sent again after the timeout
+	msg := msgForId(3)
+	if err := rt.Send(msg[:]); err != nil {
+		t.Fatal(err)
+	}
+	lost := ms.onions.onions
+	ms.onions.onions = nil
+	if confirmed, err := rt.Poll(); err != nil || confirmed != 0 {
+		t.Fatalf("Poll confirmed %d messages (%v), want 0", confirmed, err)
+	}
+	if n := ms.onions.len(); n != 0 {
+		t.Errorf("resent %d messages before the timeout", n)
+	}
+	now = now.Add(time.Minute)
+	if _, err := rt.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	if n := ms.onions.len(); n != 1 {
+		t.Fatalf("resent %d messages after the timeout, want 1", n)
+	}
+	// with a fresh token, so that the copies cannot be linked
+	if bytes.Equal(ms.onions.onions[0][:ReceiptTokenSize], lost[0][:ReceiptTokenSize]) {
+		t.Errorf("resent the message with the same token")
+	}
+	if rt.Pending() != 1 {
+		t.Errorf("%d messages pending, want 1", rt.Pending())
+	}
+	// either copy confirms it, once
+	resent := ms.onions.onions
+	if err := ms.pushOnions(lost); err != nil {
+		t.Fatal(err)
+	}
+	if confirmed, err := rt.Poll(); err != nil || confirmed != 1 || rt.Pending() != 0 {
+		t.Errorf("Poll confirmed %d messages (%v) with %d pending, want 1 and 0", confirmed, err, rt.Pending())
+	}
+	if err := ms.pushOnions(resent); err != nil {
+		t.Fatal(err)
+	}
+	if confirmed, err := rt.Poll(); err != nil || confirmed != 0 {
+		t.Errorf("Poll confirmed %d messages (%v) for the second copy, want 0", confirmed, err)
+	}
+	if !bytes.Equal(pushed[len(pushed)-1], msg[:]) {
+		t.Errorf("pushed %v, want %v", pushed[len(pushed)-1], msg)
+	}
+
+	// receipts are still found after the node restarts with fewer batches
+	ms = newServer()
+	msg = msgForId(4)
+	if err := rt.Send(msg[:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := ms.pushOnions(ms.onions.onions); err != nil {
+		t.Fatal(err)
+	}
+	if confirmed, err := rt.Poll(); err != nil || confirmed != 1 {
+		t.Errorf("Poll confirmed %d messages (%v) after a restart, want 1", confirmed, err)
+	}
+}
@@ -0,0 +1,100 @@
+package mixnet
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Replicas that fail maxConsecutiveErrors times in a row are not used for
+// ejectionTime.
+const (
+	maxConsecutiveErrors = 3
+	ejectionTime         = 30 * time.Second
+)
+
+// PositionAddrs returns the addresses of the replicas of the node with idx.
+func (msc MixnetServerConfig) PositionAddrs(idx int) []string {
+	if idx < len(msc.Replicas) && len(msc.Replicas[idx]) > 0 {
+		return msc.Replicas[idx]
+	}
+	return []string{msc.Addrs[idx]}
+}
+
+// balancer spreads requests across the replicas of a position, skipping
+// replicas that keep failing.
+type balancer struct {
+	addrs        []string
+	failures     []int
+	ejectedUntil []time.Time
+	next         int
+	mu           sync.Mutex
+
+	now func() time.Time // defaults to time.Now
+}
+
+func newBalancer(addrs []string) *balancer {
+	return &balancer{
+		addrs:        addrs,
+		failures:     make([]int, len(addrs)),
+		ejectedUntil: make([]time.Time, len(addrs)),
+	}
+}
+
+func (b *balancer) timeNow() time.Time {
+	if b.now != nil {
+		return b.now()
+	}
+	return time.Now()
+}
+
+// candidates returns the replicas to try, in round-robin order. If all of
+// them are ejected, all of them are tried rather than none.
+func (b *balancer) candidates() []int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.timeNow()
+	var healthy, ejected []int
+	for j := range b.addrs {
+		i := (b.next + j) % len(b.addrs)
+		if now.Before(b.ejectedUntil[i]) {
+			ejected = append(ejected, i)
+		} else {
+			healthy = append(healthy, i)
+		}
+	}
+	b.next = (b.next + 1) % len(b.addrs)
+	if len(healthy) == 0 {
+		return ejected
+	}
+	return healthy
+}
+
+func (b *balancer) report(i int, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		b.failures[i] = 0
+		return
+	}
+	b.failures[i]++
+	if b.failures[i] >= maxConsecutiveErrors {
+		log.Printf("ejecting %s after %d consecutive errors: %s", b.addrs[i], b.failures[i], err.Error())
+		b.ejectedUntil[i] = b.timeNow().Add(ejectionTime)
+		b.failures[i] = 0
+	}
+}
+
+// do calls f with the address of one replica after another, until it
+// succeeds. It returns the last error if all of them fail.
+func (b *balancer) do(f func(addr string) error) error {
+	var err error
+	for _, i := range b.candidates() {
+		err = f(b.addrs[i])
+		b.report(i, err)
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
@@ -0,0 +1,130 @@
+package mixnet
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBalancer(t *testing.T) {
+	now := time.Unix(1000, 0)
+	b := newBalancer([]string{"a", "b"})
+	b.now = func() time.Time { return now }
+
+	used := make(map[string]int)
+	for i := 0; i < 10; i++ {
+		if err := b.do(func(addr string) error {
+			used[addr]++
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if used["a"] != 5 || used["b"] != 5 {
+		t.Errorf("replicas used %v times, want 5 each", used)
+	}
+
+	// b fails until it is ejected, and a takes over
+	used = make(map[string]int)
+	for i := 0; i < 20; i++ {
+		if err := b.do(func(addr string) error {
+			used[addr]++
+			if addr == "b" {
+				return fmt.Errorf("down")
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if used["b"] != maxConsecutiveErrors || used["a"] != 20 {
+		t.Errorf("replicas used %v times, want b %d times and a 20 times", used, maxConsecutiveErrors)
+	}
+
+	// b is tried again after ejectionTime
+	now = now.Add(ejectionTime)
+	used = make(map[string]int)
+	for i := 0; i < 2; i++ {
+		b.do(func(addr string) error {
+			used[addr]++
+			return nil
+		})
+	}
+	if used["b"] != 1 {
+		t.Errorf("replicas used %v times after ejectionTime, want b once", used)
+	}
+
+	// if all are ejected, all are still tried
+	err := fmt.Errorf("down")
+	for i := 0; i < maxConsecutiveErrors; i++ {
+		b.do(func(addr string) error { return err })
+	}
+	tried := 0
+	if got := b.do(func(addr string) error {
+		tried++
+		return err
+	}); got != err || tried != 2 {
+		t.Errorf("do with all replicas ejected tried %d and returned %v", tried, got)
+	}
+}
+
+func TestSendMessageReplicas(t *testing.T) {
+	var down, up int
+	downSrv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		down++
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer downSrv.Close()
+	upSrv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		up++
+		rw.WriteHeader(http.StatusAccepted)
+	}))
+	defer upSrv.Close()
+
+	mc := NewMixnetClient(&MixnetClientConfig{
+		Addr:          downSrv.URL,
+		EntryAddrs:    []string{downSrv.URL, upSrv.URL},
+		PubKeys:       [][32]byte{PubKey("key")},
+		MessageLength: messageLength,
+	})
+	for i := 0; i < 20; i++ {
+		msg := msgForId(i)
+		if err := mc.SendMessage(msg[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if up != 20 || down != maxConsecutiveErrors {
+		t.Errorf("replicas received %d and %d messages, want 20 and %d", up, down, maxConsecutiveErrors)
+	}
+}
+
+func TestMakeClientConfigReplicas(t *testing.T) {
+	serve := func(masterKey string) *httptest.Server {
+		ms := NewMixnetServer(&MixnetServerConfig{}, 0, masterKey)
+		return httptest.NewServer(http.HandlerFunc(ms.ServePubkey))
+	}
+	a, b, c := serve("key"), serve("key"), serve("other key")
+	defer a.Close()
+	defer b.Close()
+	defer c.Close()
+
+	sc := &MixnetServerConfig{
+		Addrs:         []string{a.URL},
+		Replicas:      [][]string{{a.URL, b.URL}},
+		MessageLength: messageLength,
+	}
+	conf, err := MakeClientConfig(sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conf.EntryAddrs) != 2 || conf.PubKeys[0] != PubKey("key") {
+		t.Errorf("got config %+v", conf)
+	}
+
+	sc.Replicas[0] = append(sc.Replicas[0], c.URL)
+	if _, err := MakeClientConfig(sc); err == nil {
+		t.Errorf("MakeClientConfig accepted replicas with different keys")
+	}
+}
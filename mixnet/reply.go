@@ -0,0 +1,216 @@
+package mixnet
+
+import (
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"github.com/yunwilliamyu/contact-trace-mixnet/mixnet/pb"
+	"github.com/yunwilliamyu/contact-trace-mixnet/notifier"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/salsa20"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Replies travel the chain in reverse, from the node with idx 0 to the entry
+// node, which delivers them. A reply is a single-use reply block built by the
+// recipient, followed by a payload of MessageLength:
+//
+//	header_i = box(node i, key_i + expiry + header_i+1)
+//	header_n = delivery address
+//
+// Node i opens its layer of the header, and encrypts the payload with a
+// keystream of key_i. Expired reply blocks are dropped, so nodes only have to
+// remember the keys of unexpired ones to detect replays. The recipient knows
+// all keys and removes the keystreams, while the sender of the reply (e.g.
+// the store, see store.SealReplyRequest) learns nothing about the recipient
+// but the reply block, and no node can link the reply to the recipient
+// unless all of them collude.
+
+// ReplyAddrLength is the length of delivery addresses, which are sealed
+// dead drop addresses.
+const ReplyAddrLength = notifier.SealedAddressV1Size
+
+const replyLayerOverhead = box.AnonymousOverhead + 32 + 8
+
+// ReplyBlockLifetime is how long reply blocks can be used. Expiry times are
+// rounded down to replyExpiryGranularity, so that they do not tell reply
+// blocks apart.
+const ReplyBlockLifetime = 24 * time.Hour
+
+const (
+	replyExpiryGranularity = time.Hour
+	// replies seen by a node are forgotten at most this long after they
+	// expired
+	replyReapInterval = time.Hour
+)
+
+// ReplyHeaderLength returns the length of reply blocks received by the node
+// with idx in a chain of chainLength nodes.
+func ReplyHeaderLength(idx int, chainLength int) int {
+	return ReplyAddrLength + (chainLength-idx)*replyLayerOverhead
+}
+
+func (msc MixnetServerConfig) ReplyMessageLength(idx int) int {
+	return ReplyHeaderLength(idx, len(msc.Addrs)) + msc.MessageLength
+}
+
+func replyURL(addr string) string {
+	return fmt.Sprintf("%s/v0/reply", addr)
+}
+
+// xorKeyStream encrypts or decrypts payload in place. Keys are only used
+// once, so the nonce can be fixed.
+func xorKeyStream(payload []byte, key *[32]byte) {
+	var nonce [8]byte
+	salsa20.XORKeyStream(payload, payload, nonce[:], key)
+}
+
+func (k keys) processReply(msg []byte, headerLength int) (out []byte, key [32]byte, expiry int64, err error) {
+	layer, ok := box.OpenAnonymous(nil, msg[:headerLength], &k.publicKey, &k.privateKey)
+	if !ok {
+		return nil, key, 0, fmt.Errorf("received invalid reply")
+	}
+	copy(key[:], layer[:32])
+	expiry = int64(binary.BigEndian.Uint64(layer[32:40]))
+	payload := append([]byte(nil), msg[headerLength:]...)
+	xorKeyStream(payload, &key)
+	return append(layer[40:], payload...), key, expiry, nil
+}
+
+// checkReplay records the reply block with key, and fails if it was seen
+// before or is not valid at now.
+func (ms *MixnetServer) checkReplay(key [32]byte, expiry int64) error {
+	now := ms.timeNow()
+	ms.seenMu.Lock()
+	defer ms.seenMu.Unlock()
+	if now.After(ms.nextReap) {
+		for k, e := range ms.seenReplies {
+			if e < now.Unix() {
+				delete(ms.seenReplies, k)
+			}
+		}
+		ms.nextReap = now.Add(replyReapInterval)
+	}
+	if expiry < now.Unix() {
+		return fmt.Errorf("reply block expired")
+	}
+	if expiry > now.Add(ReplyBlockLifetime).Unix() {
+		return fmt.Errorf("reply block expires too late")
+	}
+	seen := sha256.Sum256(key[:])
+	if _, replayed := ms.seenReplies[seen]; replayed {
+		return fmt.Errorf("reply block was used before")
+	}
+	ms.seenReplies[seen] = expiry
+	return nil
+}
+
+// ReceiveReply processes a batch of replies for the next node on the way
+// back, or for delivery if ms is the entry node.
+func (ms *MixnetServer) ReceiveReply(req *pb.PutOnionsRequest) error {
+	if ms.replies.len()+len(req.Msgs) > ms.conf.MaxBufferedMessages {
+		return fmt.Errorf("too many buffered messages")
+	}
+
+	headerLength := ReplyHeaderLength(ms.idx, len(ms.conf.Addrs))
+	for _, msg := range req.Msgs {
+		if len(msg) != ms.conf.ReplyMessageLength(ms.idx) {
+			log.Printf("received reply of invalid length")
+			continue
+		}
+		out, key, expiry, err := ms.keys.processReply(msg, headerLength)
+		if err != nil {
+			log.Printf("received invalid reply: %s", err.Error())
+			continue
+		}
+		// reply blocks are single-use, replaying one would allow following
+		// it through the chain
+		if err := ms.checkReplay(key, expiry); err != nil {
+			log.Printf("dropping reply: %s", err.Error())
+			continue
+		}
+		ms.addMessage(ms.replies, out)
+	}
+	return nil
+}
+
+func (ms *MixnetServer) ServeReply(rw http.ResponseWriter, req *http.Request) {
+	ServeOnions(rw, req, ms.conf.ReplyMessageLength(ms.idx), ms.ReceiveReply)
+}
+
+func (ms *MixnetServer) pushReplies(onions [][]byte) error {
+	if ms.idx < len(ms.conf.Addrs)-1 {
+		return ms.prev.do(func(addr string) error {
+			return pushTo(replyURL(addr), onions, nil)
+		})
+	}
+	// the entry node delivers the replies, each is a delivery address
+	// followed by the payload
+	if ms.ReplyHandler == nil {
+		return fmt.Errorf("no ReplyHandler to deliver replies")
+	}
+	return ms.ReplyHandler(onions)
+}
+
+// SendReplies posts replies built with WrapReply to the node with idx 0,
+// whose address is addr.
+func SendReplies(addr string, replies [][]byte) error {
+	return pushTo(replyURL(addr), replies, nil)
+}
+
+// ReplySecret lets the recipient read the reply sent with a reply block. It
+// must not leave the client.
+type ReplySecret struct {
+	Keys [][32]byte
+}
+
+// NewReplyBlock returns a single-use reply block through the given cascade
+// (e.g. one chosen with ChooseCascade), which lets someone send a reply of
+// MessageLength to deliveryAddr without learning it. The reply has to be
+// sent to the node with idx 0 of that cascade, within ReplyBlockLifetime.
+func (mc *MixnetClient) NewReplyBlock(cascade int, deliveryAddr []byte) ([]byte, *ReplySecret, error) {
+	if len(deliveryAddr) != ReplyAddrLength {
+		return nil, nil, fmt.Errorf("wrong delivery address size: %d!=%d", len(deliveryAddr), ReplyAddrLength)
+	}
+	pubKeys := mc.conf.cascades()[cascade].PubKeys
+	secret := &ReplySecret{Keys: make([][32]byte, len(pubKeys))}
+	header := append([]byte(nil), deliveryAddr...)
+	var expiry [8]byte
+	binary.BigEndian.PutUint64(expiry[:], uint64(time.Now().Add(ReplyBlockLifetime).Truncate(replyExpiryGranularity).Unix()))
+	// the entry node, which is processed last, is sealed first
+	for i := len(pubKeys) - 1; i >= 0; i-- {
+		if _, err := io.ReadFull(cryptorand.Reader, secret.Keys[i][:]); err != nil {
+			return nil, nil, err
+		}
+		var err error
+		layer := append(append(append([]byte(nil), secret.Keys[i][:]...), expiry[:]...), header...)
+		header, err = box.SealAnonymous(nil, layer, &pubKeys[i], cryptorand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return header, secret, nil
+}
+
+// WrapReply returns the reply to send with a reply block. payload must be
+// MessageLength long, and should be encrypted for the recipient.
+func WrapReply(replyBlock []byte, payload []byte) []byte {
+	return append(append([]byte(nil), replyBlock...), payload...)
+}
+
+// Open returns the payload of a delivered reply, without the delivery
+// address.
+func (rs *ReplySecret) Open(delivered []byte) ([]byte, error) {
+	if len(delivered) < ReplyAddrLength {
+		return nil, fmt.Errorf("reply too short: %d bytes", len(delivered))
+	}
+	payload := append([]byte(nil), delivered[ReplyAddrLength:]...)
+	for i := range rs.Keys {
+		xorKeyStream(payload, &rs.Keys[i])
+	}
+	return payload, nil
+}
@@ -0,0 +1,120 @@
+package mixnet
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/yunwilliamyu/contact-trace-mixnet/mixnet/pb"
+	"testing"
+	"time"
+)
+
+func TestReply(t *testing.T) {
+	const depth = 3
+	msc := &MixnetServerConfig{
+		MinBatchSize:        1,
+		MessageLength:       messageLength,
+		MaxBufferedMessages: 1000,
+		Addrs:               make([]string, depth),
+	}
+	servers := make([]*MixnetServer, depth)
+	mc := &MixnetClientConfig{PubKeys: make([][32]byte, depth), MessageLength: messageLength}
+	for i := range servers {
+		masterKey := fmt.Sprintf("key%d", i)
+		servers[i] = NewMixnetServer(msc, i, masterKey)
+		mc.PubKeys[i] = PubKey(masterKey)
+	}
+	cl := NewMixnetClient(mc)
+
+	deliveryAddr := bytes.Repeat([]byte{7}, ReplyAddrLength)
+	replyBlock, secret, err := cl.NewReplyBlock(cl.ChooseCascade(), deliveryAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := []byte("0123456789")
+	reply := WrapReply(replyBlock, payload)
+
+	// pass the reply back through the chain, the way the push loops would
+	msgs := [][]byte{reply}
+	for i, ms := range servers {
+		if len(msgs[0]) != msc.ReplyMessageLength(i) {
+			t.Fatalf("reply at node %d is %d bytes long, want %d", i, len(msgs[0]), msc.ReplyMessageLength(i))
+		}
+		if err := ms.ReceiveReply(&pb.PutOnionsRequest{Msgs: msgs}); err != nil {
+			t.Fatal(err)
+		}
+		msgs = ms.replies.onions
+		if len(msgs) != 1 {
+			t.Fatalf("node %d has %d replies, want 1", i, len(msgs))
+		}
+		if bytes.Contains(msgs[0], payload) {
+			t.Errorf("payload is visible after node %d", i)
+		}
+	}
+
+	delivered := msgs[0]
+	if !bytes.Equal(delivered[:ReplyAddrLength], deliveryAddr) {
+		t.Errorf("delivered to %x, want %x", delivered[:ReplyAddrLength], deliveryAddr)
+	}
+	got, err := secret.Open(delivered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Errorf("opened reply %q, want %q", got, payload)
+	}
+
+	// reply blocks are single-use
+	if err := servers[0].ReceiveReply(&pb.PutOnionsRequest{Msgs: [][]byte{reply}}); err != nil {
+		t.Fatal(err)
+	}
+	if n := servers[0].replies.len(); n != 1 {
+		t.Errorf("node 0 accepted a replayed reply")
+	}
+}
+
+func TestReplyExpiry(t *testing.T) {
+	msc := &MixnetServerConfig{
+		MinBatchSize:        1,
+		MessageLength:       messageLength,
+		MaxBufferedMessages: 1000,
+		Addrs:               make([]string, 1),
+	}
+	ms := NewMixnetServer(msc, 0, "key0")
+	now := time.Now()
+	ms.now = func() time.Time { return now }
+	cl := NewMixnetClient(&MixnetClientConfig{PubKeys: [][32]byte{PubKey("key0")}, MessageLength: messageLength})
+	reply := func() []byte {
+		replyBlock, _, err := cl.NewReplyBlock(0, bytes.Repeat([]byte{7}, ReplyAddrLength))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return WrapReply(replyBlock, make([]byte, messageLength))
+	}
+	receive := func(reply []byte, want int) {
+		t.Helper()
+		ms.replies.onions = nil
+		if err := ms.ReceiveReply(&pb.PutOnionsRequest{Msgs: [][]byte{reply}}); err != nil {
+			t.Fatal(err)
+		}
+		if got := ms.replies.len(); got != want {
+			t.Errorf("accepted %d replies, want %d", got, want)
+		}
+	}
+
+	old := reply()
+	receive(old, 1)
+	if len(ms.seenReplies) != 1 {
+		t.Fatalf("%d replies seen, want 1", len(ms.seenReplies))
+	}
+
+	// expired reply blocks are dropped, and forgotten
+	now = now.Add(ReplyBlockLifetime + replyReapInterval)
+	receive(old, 0)
+	if len(ms.seenReplies) != 0 {
+		t.Errorf("%d replies seen after they expired, want 0", len(ms.seenReplies))
+	}
+
+	// so are reply blocks that expire too late
+	now = now.Add(-2 * ReplyBlockLifetime)
+	receive(reply(), 0)
+}
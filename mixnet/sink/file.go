@@ -0,0 +1,80 @@
+package sink
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileSink writes records to files in Dir, named by the time they were
+// created. It starts a new file once the current one reaches MaxFileSize;
+// records are never split across files.
+type FileSink struct {
+	Dir         string
+	Format      Format
+	MaxFileSize int64 // 0 means no rotation
+
+	f    *os.File
+	size int64
+	mu   sync.Mutex
+}
+
+// rotate must be called with fs.mu held.
+func (fs *FileSink) rotate(now time.Time) error {
+	if fs.f != nil {
+		if err := fs.f.Close(); err != nil {
+			return err
+		}
+		fs.f = nil
+	}
+	name := filepath.Join(fs.Dir, fmt.Sprintf("batches-%d.%s", now.UnixNano(), fs.Format))
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	fs.f = f
+	fs.size = 0
+	return nil
+}
+
+func (fs *FileSink) Push(msgs [][]byte) error {
+	now := time.Now()
+	record, err := fs.Format.Encode(msgs, now)
+	if err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.f == nil || (fs.MaxFileSize > 0 && fs.size >= fs.MaxFileSize) {
+		if err := fs.rotate(now); err != nil {
+			return err
+		}
+	}
+	n, err := fs.f.Write(record)
+	fs.size += int64(n)
+	if err == nil {
+		// the batch is dropped by the node once Push returns
+		err = fs.f.Sync()
+	}
+	if err != nil {
+		// do not append to a file that may end in a partial record, the
+		// retry goes to a new one
+		fs.f.Close()
+		fs.f = nil
+		return err
+	}
+	return nil
+}
+
+func (fs *FileSink) Close() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.f == nil {
+		return nil
+	}
+	err := fs.f.Close()
+	fs.f = nil
+	return err
+}
@@ -0,0 +1,54 @@
+package sink
+
+import (
+	"context"
+	"github.com/yunwilliamyu/contact-trace-mixnet/notifier"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+)
+
+// Notifier is implemented by *notifier.NotifierClient.
+type Notifier interface {
+	Notify(ctx context.Context, address []byte, msg []byte) error
+}
+
+// NotifierSink delivers every message directly to a dead drop. Messages are
+// a sealed address (see notifier.DeadDropClient.MakeAddressV1) followed by
+// the contents of the notification. There are no batches in dead drops, and
+// notifications are timestamped by the notifier.
+type NotifierSink struct {
+	Notifier Notifier
+}
+
+// permanent reports whether the notifier rejected a message itself, so that
+// retrying it cannot help.
+func permanent(err error) bool {
+	switch status.Code(err) {
+	case codes.InvalidArgument, codes.OutOfRange:
+		return true
+	}
+	return false
+}
+
+// Push delivers the messages in order. Messages which the notifier rejects,
+// e.g. because their address cannot be opened, are dropped. If it fails
+// otherwise, the whole batch is retried, so messages are delivered at least
+// once.
+func (ns *NotifierSink) Push(msgs [][]byte) error {
+	for _, msg := range msgs {
+		if len(msg) < notifier.SealedAddressV1Size {
+			log.Printf("message too short for a notification: %d bytes", len(msg))
+			continue
+		}
+		address, contents := msg[:notifier.SealedAddressV1Size], msg[notifier.SealedAddressV1Size:]
+		if err := ns.Notifier.Notify(context.TODO(), address, contents); err != nil {
+			if permanent(err) {
+				log.Printf("dropping notification: %s", err.Error())
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
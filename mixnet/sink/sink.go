@@ -0,0 +1,151 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"github.com/yunwilliamyu/contact-trace-mixnet/mixnet/pb"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"sync"
+	"time"
+)
+
+// Sink receives the batches pushed by the final mixnet node. Push can be used
+// as MixnetServer.PushHandler; it is retried with the same batch if it fails.
+type Sink interface {
+	Push(msgs [][]byte) error
+}
+
+// Format is how batches are written to files and sockets. Every batch is
+// written as one record, which keeps its messages and the time at which it
+// was pushed.
+type Format string
+
+const (
+	// LengthPrefixed writes a header of the number of messages (uint32) and
+	// the timestamp in unix nanoseconds (int64), followed by each message
+	// prefixed with its length (uint32), all big-endian.
+	LengthPrefixed Format = "length_prefixed"
+	// Protobuf writes a pb.Batch prefixed with its length as a varint, like
+	// protobuf's delimited streams.
+	Protobuf Format = "protobuf"
+)
+
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case LengthPrefixed, Protobuf:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown format %q", s)
+	}
+}
+
+// Encode returns the record of a batch pushed at t.
+func (f Format) Encode(msgs [][]byte, t time.Time) ([]byte, error) {
+	switch f {
+	case LengthPrefixed:
+		size := 12
+		for _, msg := range msgs {
+			size += 4 + len(msg)
+		}
+		record := make([]byte, 12, size)
+		binary.BigEndian.PutUint32(record, uint32(len(msgs)))
+		binary.BigEndian.PutUint64(record[4:], uint64(t.UnixNano()))
+		for _, msg := range msgs {
+			var length [4]byte
+			binary.BigEndian.PutUint32(length[:], uint32(len(msg)))
+			record = append(record, length[:]...)
+			record = append(record, msg...)
+		}
+		return record, nil
+	case Protobuf:
+		batch, err := proto.Marshal(&pb.Batch{Timestamp: t.UnixNano(), Msgs: msgs})
+		if err != nil {
+			return nil, err
+		}
+		var length [binary.MaxVarintLen64]byte
+		n := binary.PutUvarint(length[:], uint64(len(batch)))
+		return append(length[:n], batch...), nil
+	default:
+		return nil, fmt.Errorf("unknown format %q", f)
+	}
+}
+
+// maxRecordSize guards ReadBatch against allocating huge buffers for corrupt
+// input.
+const maxRecordSize = 1 << 30
+
+// ReadBatch reads the next record from r. It returns io.EOF if there are no
+// more records, and io.ErrUnexpectedEOF if the last one is truncated.
+func ReadBatch(r *bufio.Reader, f Format) (*pb.Batch, error) {
+	switch f {
+	case LengthPrefixed:
+		var header [12]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return nil, err
+		}
+		batch := &pb.Batch{Timestamp: int64(binary.BigEndian.Uint64(header[4:]))}
+		for i := binary.BigEndian.Uint32(header[:]); i > 0; i-- {
+			var length [4]byte
+			if _, err := io.ReadFull(r, length[:]); err != nil {
+				return nil, unexpected(err)
+			}
+			n := binary.BigEndian.Uint32(length[:])
+			if n > maxRecordSize {
+				return nil, fmt.Errorf("message too long: %d bytes", n)
+			}
+			msg := make([]byte, n)
+			if _, err := io.ReadFull(r, msg); err != nil {
+				return nil, unexpected(err)
+			}
+			batch.Msgs = append(batch.Msgs, msg)
+		}
+		return batch, nil
+	case Protobuf:
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		if n > maxRecordSize {
+			return nil, fmt.Errorf("record too long: %d bytes", n)
+		}
+		record := make([]byte, n)
+		if _, err := io.ReadFull(r, record); err != nil {
+			return nil, unexpected(err)
+		}
+		batch := &pb.Batch{}
+		if err := proto.Unmarshal(record, batch); err != nil {
+			return nil, err
+		}
+		return batch, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q", f)
+	}
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// WriterSink writes records to W, e.g. os.Stdout.
+type WriterSink struct {
+	W      io.Writer
+	Format Format
+
+	mu sync.Mutex
+}
+
+func (ws *WriterSink) Push(msgs [][]byte) error {
+	record, err := ws.Format.Encode(msgs, time.Now())
+	if err != nil {
+		return err
+	}
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	_, err = ws.W.Write(record)
+	return err
+}
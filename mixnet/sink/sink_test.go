@@ -0,0 +1,186 @@
+package sink
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/yunwilliamyu/contact-trace-mixnet/notifier"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var batches = [][][]byte{
+	{[]byte("a"), []byte("bc")},
+	{},
+	{[]byte(""), []byte("def")},
+}
+
+// readAll reads all records from r, and checks that they are batches.
+func readAll(t *testing.T, r io.Reader, format Format, want [][][]byte) {
+	t.Helper()
+	br := bufio.NewReader(r)
+	for i, msgs := range want {
+		batch, err := ReadBatch(br, format)
+		if err != nil {
+			t.Fatalf("ReadBatch %d: %s", i, err.Error())
+		}
+		if batch.Timestamp == 0 {
+			t.Errorf("batch %d has no timestamp", i)
+		}
+		if len(batch.Msgs) != len(msgs) {
+			t.Fatalf("batch %d has %d messages, want %d", i, len(batch.Msgs), len(msgs))
+		}
+		for j := range msgs {
+			if !bytes.Equal(batch.Msgs[j], msgs[j]) {
+				t.Errorf("message %d of batch %d is %q, want %q", j, i, batch.Msgs[j], msgs[j])
+			}
+		}
+	}
+	if _, err := ReadBatch(br, format); err != io.EOF {
+		t.Errorf("ReadBatch at the end returned %v, want EOF", err)
+	}
+}
+
+func TestWriterSink(t *testing.T) {
+	for _, format := range []Format{LengthPrefixed, Protobuf} {
+		var buf bytes.Buffer
+		ws := &WriterSink{W: &buf, Format: format}
+		for _, msgs := range batches {
+			if err := ws.Push(msgs); err != nil {
+				t.Fatal(err)
+			}
+		}
+		readAll(t, bytes.NewReader(buf.Bytes()), format, batches)
+
+		// truncated records are detected
+		truncated := buf.Bytes()[:buf.Len()-1]
+		br := bufio.NewReader(bytes.NewReader(truncated))
+		var err error
+		for err == nil {
+			_, err = ReadBatch(br, format)
+		}
+		if err != io.ErrUnexpectedEOF {
+			t.Errorf("%s: ReadBatch of a truncated record returned %v", format, err)
+		}
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fs := &FileSink{Dir: dir, Format: Protobuf, MaxFileSize: 1}
+	for _, msgs := range batches {
+		if err := fs.Push(msgs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "batches-*.protobuf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every batch fills a file
+	if len(files) != len(batches) {
+		t.Fatalf("got %d files, want %d", len(files), len(batches))
+	}
+	for i, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		readAll(t, f, Protobuf, batches[i:i+1])
+		f.Close()
+	}
+}
+
+func TestUnixSocketSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sink.sock")
+	lis, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	received := make(chan []byte)
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			close(received)
+			return
+		}
+		contents, _ := ioutil.ReadAll(conn)
+		received <- contents
+	}()
+
+	us := &UnixSocketSink{Path: path, Format: LengthPrefixed}
+	for _, msgs := range batches {
+		if err := us.Push(msgs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := us.Close(); err != nil {
+		t.Fatal(err)
+	}
+	readAll(t, bytes.NewReader(<-received), LengthPrefixed, batches)
+}
+
+type fakeNotifier struct {
+	sent []string
+	down bool
+}
+
+func (fn *fakeNotifier) Notify(ctx context.Context, address []byte, msg []byte) error {
+	if fn.down {
+		return status.Error(codes.Unavailable, "down")
+	}
+	if address[0] != 'x' {
+		return status.Error(codes.InvalidArgument, "cannot decrypt address")
+	}
+	fn.sent = append(fn.sent, string(address[:1])+string(msg))
+	return nil
+}
+
+func TestNotifierSink(t *testing.T) {
+	fn := &fakeNotifier{}
+	ns := &NotifierSink{Notifier: fn}
+	address := bytes.Repeat([]byte("x"), notifier.SealedAddressV1Size)
+	if err := ns.Push([][]byte{append(address, "hello"...), []byte("too short")}); err != nil {
+		t.Fatal(err)
+	}
+	if len(fn.sent) != 1 || fn.sent[0] != "xhello" {
+		t.Errorf("sent %q, want [xhello]", fn.sent)
+	}
+
+	// a message with a bad address does not hold up the others
+	fn.sent = nil
+	bad := bytes.Repeat([]byte("y"), notifier.SealedAddressV1Size)
+	batch := [][]byte{append(address, "a"...), append(bad, "b"...), append(address, "c"...)}
+	if err := ns.Push(batch); err != nil {
+		t.Fatalf("Push failed because of a bad message: %s", err.Error())
+	}
+	if fmt.Sprint(fn.sent) != "[xa xc]" {
+		t.Errorf("sent %q, want [xa xc]", fn.sent)
+	}
+
+	// but the batch is retried if the notifier is down
+	fn.down = true
+	if err := ns.Push(batch); err == nil {
+		t.Errorf("Push did not fail while the notifier is down")
+	}
+}
@@ -0,0 +1,54 @@
+package sink
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+const socketWriteTimeout = 10 * time.Second
+
+// UnixSocketSink writes records to the Unix socket at Path, for a local
+// consumer listening there. It reconnects if the connection breaks; the
+// consumer should discard a partial record at the end of a connection.
+type UnixSocketSink struct {
+	Path   string
+	Format Format
+
+	conn net.Conn
+	mu   sync.Mutex
+}
+
+func (us *UnixSocketSink) Push(msgs [][]byte) error {
+	record, err := us.Format.Encode(msgs, time.Now())
+	if err != nil {
+		return err
+	}
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	if us.conn == nil {
+		conn, err := net.Dial("unix", us.Path)
+		if err != nil {
+			return err
+		}
+		us.conn = conn
+	}
+	us.conn.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+	if _, err := us.conn.Write(record); err != nil {
+		us.conn.Close()
+		us.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (us *UnixSocketSink) Close() error {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	if us.conn == nil {
+		return nil
+	}
+	err := us.conn.Close()
+	us.conn = nil
+	return err
+}
@@ -7,13 +7,19 @@ import (
 	"golang.org/x/crypto/nacl/box"
 )
 
+// AddressV1Size is the size of unsealed dead drop addresses.
+const AddressV1Size = IDSize + 2
+
+// SealedAddressV1Size is the size of the addresses returned by MakeAddressV1.
+const SealedAddressV1Size = box.AnonymousOverhead + AddressV1Size
+
 func (ps *PollServer) unsealAddressV1(addr []byte) (hint uint16, deaddropID DeadDropID, err error) {
 	// check len?
-	decAddr, ok := box.OpenAnonymous(nil, addr, &ps.conf.PublicKey, &ps.privateKey)
+	decAddr, ok := box.OpenAnonymous(nil, addr, &ps.publicKey, &ps.privateKey)
 	if !ok {
 		return hint, deaddropID, fmt.Errorf("cannot decrypt address")
 	}
-	if got, want := len(decAddr), IDSize+2; got != want {
+	if got, want := len(decAddr), AddressV1Size; got != want {
 		return hint, deaddropID, fmt.Errorf("invalid address length: %d, expected %d", got, want)
 	}
 	copy(deaddropID[:], decAddr[:IDSize])
@@ -22,7 +28,7 @@ func (ps *PollServer) unsealAddressV1(addr []byte) (hint uint16, deaddropID Dead
 }
 
 func (dcl *DeadDropClient) MakeAddressV1(id uint16) []byte {
-	var rawAddress [IDSize + 2]byte
+	var rawAddress [AddressV1Size]byte
 	copy(rawAddress[:IDSize], dcl.address[:])
 	binary.LittleEndian.PutUint16(rawAddress[IDSize:], id)
 	encAddress, err := box.SealAnonymous(nil, rawAddress[:], &dcl.conf.PublicKey, cryptorand.Reader)
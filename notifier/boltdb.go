@@ -0,0 +1,175 @@
+package notifier
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/yunwilliamyu/contact-trace-mixnet/notifier/pb"
+	bolt "go.etcd.io/bbolt"
+	"math"
+	"time"
+)
+
+var deaddropsBucket = []byte("deaddrops")
+
+// BoltDB stores dead drops on disk. Each dead drop is a bucket of
+// notifications keyed by their cursor, so that they are iterated in order of
+// arrival. The sequence of the dead drops bucket is the floor for the
+// sequence numbers of new dead drops.
+type BoltDB struct {
+	db *bolt.DB
+}
+
+func OpenBoltDB(path string) (*BoltDB, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(deaddropsBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltDB{db: db}, nil
+}
+
+func (bdb *BoltDB) Close() error {
+	return bdb.db.Close()
+}
+
+func (bdb *BoltDB) Put(deaddropID DeadDropID, message *pb.Notification) error {
+	stamp(message)
+	// Update commits (and fsyncs) before returning, so a successful Put
+	// survives crashes.
+	return bdb.db.Update(func(tx *bolt.Tx) error {
+		deaddrops := tx.Bucket(deaddropsBucket)
+		d, err := deaddrops.CreateBucketIfNotExists(deaddropID[:])
+		if err != nil {
+			return err
+		}
+		prev := d.Sequence()
+		if prev < deaddrops.Sequence() {
+			prev = deaddrops.Sequence()
+		}
+		seq := prev + 1
+		if err := d.SetSequence(seq); err != nil {
+			return err
+		}
+		message.Cursor = encodeCursor(seq)
+		value, err := proto.Marshal(message)
+		if err != nil {
+			return err
+		}
+		return d.Put(message.Cursor, value)
+	})
+}
+
+func (bdb *BoltDB) Fetch(deaddropID DeadDropID, handler func(messages []*pb.Notification) (dropPrefix int, err error)) error {
+	// reading and dropping the prefix happen in one transaction, so they
+	// are atomic with respect to concurrent Puts and Fetches
+	return bdb.db.Update(func(tx *bolt.Tx) error {
+		d := tx.Bucket(deaddropsBucket).Bucket(deaddropID[:])
+		if d == nil {
+			_, err := handler(nil)
+			return err
+		}
+		var keys [][]byte
+		var messages []*pb.Notification
+		if err := d.ForEach(func(k, v []byte) error {
+			n := &pb.Notification{}
+			if err := proto.Unmarshal(v, n); err != nil {
+				return err
+			}
+			keys = append(keys, append([]byte(nil), k...))
+			messages = append(messages, n)
+			return nil
+		}); err != nil {
+			return err
+		}
+		dropN, err := handler(messages)
+		if err != nil {
+			return err
+		}
+		for _, k := range keys[:dropN] {
+			if err := d.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (bdb *BoltDB) After(deaddropID DeadDropID, seq uint64) ([]*pb.Notification, error) {
+	if seq == math.MaxUint64 {
+		return nil, nil
+	}
+	var messages []*pb.Notification
+	err := bdb.db.View(func(tx *bolt.Tx) error {
+		d := tx.Bucket(deaddropsBucket).Bucket(deaddropID[:])
+		if d == nil {
+			return nil
+		}
+		c := d.Cursor()
+		for k, v := c.Seek(encodeCursor(seq + 1)); k != nil; k, v = c.Next() {
+			n := &pb.Notification{}
+			if err := proto.Unmarshal(v, n); err != nil {
+				return err
+			}
+			messages = append(messages, n)
+		}
+		return nil
+	})
+	return messages, err
+}
+
+func (bdb *BoltDB) Expire(cutoff time.Time) (ExpiryStats, error) {
+	var stats ExpiryStats
+	err := bdb.db.Update(func(tx *bolt.Tx) error {
+		stats = ExpiryStats{}
+		deaddrops := tx.Bucket(deaddropsBucket)
+		var empty [][]byte
+		floor := deaddrops.Sequence()
+		if err := deaddrops.ForEach(func(id, _ []byte) error {
+			d := deaddrops.Bucket(id)
+			var expiredKeys [][]byte
+			remaining := 0
+			if err := d.ForEach(func(k, v []byte) error {
+				n := &pb.Notification{}
+				if err := proto.Unmarshal(v, n); err != nil {
+					return err
+				}
+				if expired(n, cutoff) {
+					stats.addNotification(n)
+					expiredKeys = append(expiredKeys, append([]byte(nil), k...))
+				} else {
+					remaining++
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			for _, k := range expiredKeys {
+				if err := d.Delete(k); err != nil {
+					return err
+				}
+			}
+			if remaining == 0 {
+				empty = append(empty, append([]byte(nil), id...))
+				if d.Sequence() > floor {
+					floor = d.Sequence()
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, id := range empty {
+			if err := deaddrops.DeleteBucket(id); err != nil {
+				return err
+			}
+			stats.DeadDrops++
+		}
+		return deaddrops.SetSequence(floor)
+	})
+	return stats, err
+}
@@ -0,0 +1,36 @@
+package notifier
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const cursorSize = 8
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// Cursors are sequence numbers within a dead drop. Clients must treat them
+// as opaque.
+func encodeCursor(seq uint64) []byte {
+	cursor := make([]byte, cursorSize)
+	binary.BigEndian.PutUint64(cursor, seq)
+	return cursor
+}
+
+// decodeCursor returns 0 for an empty cursor, which is before every
+// notification.
+func decodeCursor(cursor []byte) (uint64, error) {
+	if len(cursor) == 0 {
+		return 0, nil
+	}
+	if len(cursor) != cursorSize {
+		return 0, errInvalidCursor
+	}
+	return binary.BigEndian.Uint64(cursor), nil
+}
+
+// Sequence numbers are counted per dead drop. When Expire deletes a dead
+// drop, the DB keeps its last sequence number as a floor, and dead drops
+// created later count from there. So sequence numbers never go back, and
+// cursors acknowledged before a dead drop was deleted do not cover
+// notifications stored after it is used again.
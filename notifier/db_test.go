@@ -0,0 +1,279 @@
+package notifier
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/yunwilliamyu/contact-trace-mixnet/notifier/pb"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func notification(i int) *pb.Notification {
+	return &pb.Notification{Hint: uint32(i), Contents: []byte(fmt.Sprintf("message %d", i))}
+}
+
+func fetchAll(t *testing.T, db DB, id DeadDropID, dropPrefix int) []*pb.Notification {
+	var got []*pb.Notification
+	if err := db.Fetch(id, func(messages []*pb.Notification) (int, error) {
+		got = append(got, messages...)
+		if dropPrefix > len(messages) {
+			return len(messages), nil
+		}
+		return dropPrefix, nil
+	}); err != nil {
+		t.Fatalf("Fetch: %s", err.Error())
+	}
+	return got
+}
+
+func checkHints(t *testing.T, got []*pb.Notification, want ...int) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d notifications, want %d", len(got), len(want))
+	}
+	for i, n := range got {
+		if i > 0 && bytes.Compare(got[i-1].Cursor, n.Cursor) >= 0 {
+			t.Errorf("cursor of notification %d does not increase", i)
+		}
+		if n.Hint != uint32(want[i]) || string(n.Contents) != string(notification(want[i]).Contents) {
+			t.Errorf("notification %d is %v, want %v", i, n, notification(want[i]))
+		}
+	}
+}
+
+// testDB checks that db behaves like every DB implementation should. db
+// must be empty.
+func testDB(t *testing.T, db DB) {
+	var a, b DeadDropID
+	a[0] = 1
+	b[0] = 2
+
+	checkHints(t, fetchAll(t, db, a, 0))
+
+	for i := 0; i < 5; i++ {
+		if err := db.Put(a, notification(i)); err != nil {
+			t.Fatalf("Put: %s", err.Error())
+		}
+	}
+	if err := db.Put(b, notification(100)); err != nil {
+		t.Fatalf("Put: %s", err.Error())
+	}
+
+	// nothing acknowledged
+	checkHints(t, fetchAll(t, db, a, 0), 0, 1, 2, 3, 4)
+
+	// After reads without dropping
+	all := fetchAll(t, db, a, 0)
+	seq, err := decodeCursor(all[1].Cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, after := range []struct {
+		seq  uint64
+		want []int
+	}{{0, []int{0, 1, 2, 3, 4}}, {seq, []int{2, 3, 4}}, {math.MaxUint64, nil}} {
+		got, err := db.After(a, after.seq)
+		if err != nil {
+			t.Fatalf("After: %s", err.Error())
+		}
+		checkHints(t, got, after.want...)
+	}
+	if got, err := db.After(DeadDropID{9}, 0); err != nil || len(got) != 0 {
+		t.Errorf("After returned %d notifications (error %v) of a missing dead drop", len(got), err)
+	}
+	checkHints(t, fetchAll(t, db, a, 2), 0, 1, 2, 3, 4)
+	checkHints(t, fetchAll(t, db, a, 0), 2, 3, 4)
+
+	// a failed handler drops nothing
+	errHandler := errors.New("handler failed")
+	if err := db.Fetch(a, func(messages []*pb.Notification) (int, error) {
+		return len(messages), errHandler
+	}); err != errHandler {
+		t.Errorf("Fetch returned %v, want %v", err, errHandler)
+	}
+	checkHints(t, fetchAll(t, db, a, 0), 2, 3, 4)
+
+	if err := db.Put(a, notification(5)); err != nil {
+		t.Fatalf("Put: %s", err.Error())
+	}
+	checkHints(t, fetchAll(t, db, a, 3), 2, 3, 4, 5)
+	checkHints(t, fetchAll(t, db, a, 0), 5)
+
+	// other dead drops are unaffected
+	got := fetchAll(t, db, b, 1)
+	checkHints(t, got, 100)
+	lastB := got[0].Cursor
+	checkHints(t, fetchAll(t, db, b, 0))
+
+	// expiry
+	var c, d DeadDropID
+	c[0] = 3
+	d[0] = 4
+	for _, put := range []struct {
+		id         DeadDropID
+		hint       int
+		receivedAt int64
+	}{{c, 200, 100}, {c, 201, 200}, {d, 300, 100}} {
+		n := notification(put.hint)
+		n.ReceivedAt = put.receivedAt
+		if err := db.Put(put.id, n); err != nil {
+			t.Fatalf("Put: %s", err.Error())
+		}
+	}
+	stats, err := db.Expire(time.Unix(150, 0))
+	if err != nil {
+		t.Fatalf("Expire: %s", err.Error())
+	}
+	wantStats := ExpiryStats{
+		Notifications: 2,
+		Bytes:         len(notification(200).Contents) + len(notification(300).Contents),
+		DeadDrops:     2, // b and d
+	}
+	if stats != wantStats {
+		t.Errorf("Expire returned %+v, want %+v", stats, wantStats)
+	}
+	got = fetchAll(t, db, c, 0)
+	checkHints(t, got, 201)
+	if len(got) == 1 && got[0].ReceivedAt != 200 {
+		t.Errorf("ReceivedAt is %d, want %d", got[0].ReceivedAt, 200)
+	}
+	checkHints(t, fetchAll(t, db, d, 0))
+
+	// cursors keep increasing when a deleted dead drop is used again
+	if err := db.Put(b, notification(101)); err != nil {
+		t.Fatalf("Put: %s", err.Error())
+	}
+	got = fetchAll(t, db, b, 0)
+	checkHints(t, got, 101)
+	if len(got) == 1 && bytes.Compare(got[0].Cursor, lastB) <= 0 {
+		t.Errorf("cursor %x after expiry is not after %x", got[0].Cursor, lastB)
+	}
+}
+
+// expireDrop stores a notification in a dead drop and expires everything, and
+// returns the cursor of the notification.
+func expireDrop(t *testing.T, db DB, id DeadDropID) []byte {
+	t.Helper()
+	if err := db.Put(id, notification(1)); err != nil {
+		t.Fatalf("Put: %s", err.Error())
+	}
+	got := fetchAll(t, db, id, 0)
+	if _, err := db.Expire(time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Expire: %s", err.Error())
+	}
+	return got[len(got)-1].Cursor
+}
+
+// checkAfter checks that cursors in the dead drop come after last.
+func checkAfter(t *testing.T, db DB, id DeadDropID, last []byte) {
+	t.Helper()
+	if err := db.Put(id, notification(2)); err != nil {
+		t.Fatalf("Put: %s", err.Error())
+	}
+	got := fetchAll(t, db, id, 0)
+	checkHints(t, got, 2)
+	if bytes.Compare(got[0].Cursor, last) <= 0 {
+		t.Errorf("cursor %x after reopening is not after %x", got[0].Cursor, last)
+	}
+}
+
+func TestInMemoryDB(t *testing.T) {
+	testDB(t, &InMemoryDB{})
+}
+
+func tempBoltDB(t *testing.T) (*BoltDB, string, func()) {
+	dir, err := ioutil.TempDir("", "notifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "notifier.db")
+	db, err := OpenBoltDB(path)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return db, path, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestBoltDB(t *testing.T) {
+	db, _, cleanup := tempBoltDB(t)
+	defer cleanup()
+	testDB(t, db)
+}
+
+func TestSQLiteDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "notifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "notifier.sqlite")
+	db, err := OpenSQLDB("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testDB(t, db)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// migrations are not reapplied, and the floor of sequence numbers is kept
+	db, err = OpenSQLDB("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var id DeadDropID
+	last := expireDrop(t, db, id)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = OpenSQLDB("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	checkAfter(t, db, id, last)
+}
+
+func TestBoltDBReopen(t *testing.T) {
+	db, path, cleanup := tempBoltDB(t)
+	defer cleanup()
+
+	var id DeadDropID
+	for i := 0; i < 3; i++ {
+		if err := db.Put(id, notification(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkHints(t, fetchAll(t, db, id, 1), 0, 1, 2)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := OpenBoltDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkHints(t, fetchAll(t, db, id, 0), 1, 2)
+
+	// the floor of sequence numbers is kept
+	last := expireDrop(t, db, id)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = OpenBoltDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	checkAfter(t, db, id, last)
+}
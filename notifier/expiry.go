@@ -0,0 +1,51 @@
+package notifier
+
+import (
+	"github.com/yunwilliamyu/contact-trace-mixnet/notifier/pb"
+	"log"
+	"time"
+)
+
+// ExpiryStats describes what DB.Expire reclaimed.
+type ExpiryStats struct {
+	Notifications int
+	Bytes         int
+	DeadDrops     int
+}
+
+func (es *ExpiryStats) addNotification(n *pb.Notification) {
+	es.Notifications++
+	es.Bytes += len(n.Contents)
+}
+
+// stamp records the arrival time of n, unless it already has one.
+func stamp(n *pb.Notification) {
+	if n.ReceivedAt == 0 {
+		n.ReceivedAt = time.Now().Unix()
+	}
+}
+
+func expired(n *pb.Notification, cutoff time.Time) bool {
+	return n.ReceivedAt < cutoff.Unix()
+}
+
+// Reap deletes notifications older than ps.TTL, and dead drops left empty.
+func (ps *PollServer) Reap() (ExpiryStats, error) {
+	return ps.db.Expire(time.Now().Add(-ps.TTL))
+}
+
+// RunReaper calls Reap every interval, if ps.TTL is set.
+func (ps *PollServer) RunReaper(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if ps.TTL <= 0 {
+			continue
+		}
+		stats, err := ps.Reap()
+		if err != nil {
+			log.Printf("error while reaping dead drops: %s", err.Error())
+			continue
+		}
+		log.Printf("reaped %d notifications (%d bytes) and %d dead drops", stats.Notifications, stats.Bytes, stats.DeadDrops)
+	}
+}
@@ -3,25 +3,32 @@ package notifier
 import (
 	"github.com/yunwilliamyu/contact-trace-mixnet/notifier/pb"
 	"sync"
+	"time"
 )
 
 type inMemoryDrop struct {
 	messages []*pb.Notification
+	seq      uint64
 }
 
 type InMemoryDB struct {
 	db map[DeadDropID]*inMemoryDrop
-	mu sync.Mutex
+	// sequence numbers of deleted dead drops are at most floor
+	floor uint64
+	mu    sync.Mutex
 }
 
 func (memdb *InMemoryDB) getDrop(deaddropID DeadDropID) *inMemoryDrop {
 	if memdb.db == nil {
 		memdb.db = make(map[DeadDropID]*inMemoryDrop)
+		// nothing survives a restart, so start above the sequence numbers
+		// handed out before it, unless the clock went back
+		memdb.floor = uint64(time.Now().UnixNano() / 1000)
 	}
 	if d, ok := memdb.db[deaddropID]; ok {
 		return d
 	}
-	d := &inMemoryDrop{}
+	d := &inMemoryDrop{seq: memdb.floor}
 	memdb.db[deaddropID] = d
 	return d
 }
@@ -29,6 +36,9 @@ func (memdb *InMemoryDB) getDrop(deaddropID DeadDropID) *inMemoryDrop {
 func (memdb *InMemoryDB) Put(deaddropID DeadDropID, message *pb.Notification) error {
 	memdb.mu.Lock()
 	d := memdb.getDrop(deaddropID)
+	stamp(message)
+	d.seq++
+	message.Cursor = encodeCursor(d.seq)
 	d.messages = append(d.messages, message)
 	memdb.mu.Unlock()
 	return nil
@@ -46,3 +56,45 @@ func (memdb *InMemoryDB) Fetch(deaddropID DeadDropID, handler func(messages []*p
 	d.messages = d.messages[dropN:]
 	return nil
 }
+
+func (memdb *InMemoryDB) After(deaddropID DeadDropID, seq uint64) ([]*pb.Notification, error) {
+	memdb.mu.Lock()
+	defer memdb.mu.Unlock()
+
+	d, ok := memdb.db[deaddropID]
+	if !ok {
+		return nil, nil
+	}
+	for i, n := range d.messages {
+		if s, _ := decodeCursor(n.Cursor); s > seq {
+			return append([]*pb.Notification(nil), d.messages[i:]...), nil
+		}
+	}
+	return nil, nil
+}
+
+func (memdb *InMemoryDB) Expire(cutoff time.Time) (ExpiryStats, error) {
+	memdb.mu.Lock()
+	defer memdb.mu.Unlock()
+
+	var stats ExpiryStats
+	for id, d := range memdb.db {
+		var kept []*pb.Notification
+		for _, n := range d.messages {
+			if expired(n, cutoff) {
+				stats.addNotification(n)
+			} else {
+				kept = append(kept, n)
+			}
+		}
+		d.messages = kept
+		if len(d.messages) == 0 {
+			if d.seq > memdb.floor {
+				memdb.floor = d.seq
+			}
+			delete(memdb.db, id)
+			stats.DeadDrops++
+		}
+	}
+	return stats, nil
+}
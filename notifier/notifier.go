@@ -1,13 +1,21 @@
 package notifier
 
 import (
-	"bytes"
 	"context"
 	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"github.com/golang/protobuf/proto"
 	"github.com/yunwilliamyu/contact-trace-mixnet/notifier/pb"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/nacl/box"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"io"
 	"log"
+	"net"
+	"sync"
+	"time"
 )
 
 //go:generate protoc pb/notifier.proto --go_out=plugins=grpc:.
@@ -17,8 +25,16 @@ const IDSize = 16
 type DeadDropID [IDSize]byte
 
 type DB interface {
+	// Put stores message, setting its ReceivedAt if it is not set yet, and
+	// its Cursor. Fetch returns messages ordered by cursor.
 	Put(deaddropID DeadDropID, message *pb.Notification) error
 	Fetch(deaddropID DeadDropID, handler func(messages []*pb.Notification) (dropPrefix int, err error)) error
+	// After returns the notifications with cursors after seq, without
+	// changing the dead drop.
+	After(deaddropID DeadDropID, seq uint64) ([]*pb.Notification, error)
+	// Expire deletes notifications received before cutoff, and dead drops
+	// which are left empty.
+	Expire(cutoff time.Time) (ExpiryStats, error)
 }
 
 type Config struct {
@@ -29,47 +45,140 @@ type Config struct {
 }
 
 func (c Config) DialService(ctx context.Context) (pb.NotifierClient, error) {
-	cc, err := grpc.DialContext(ctx, c.ServerAddr)
+	// TODO: TLS
+	cc, err := grpc.DialContext(ctx, c.ServerAddr, grpc.WithInsecure())
 	if err != nil {
 		return nil, err
 	}
 	return pb.NewNotifierClient(cc), nil
 }
 
+// Caps on the size of a FetchResponse. Note that gRPC rejects messages larger
+// than 4 MiB by default.
+const (
+	maxFetchResults = 1000
+	maxFetchBytes   = 1 << 20
+)
+
 type PollServer struct {
 	pb.UnimplementedNotifierServer
 
-	conf       *Config
+	publicKey  [32]byte
 	privateKey [32]byte
 	db         DB
+	// TTL is how long notifications are kept in dead drops, see RunReaper.
+	TTL time.Duration
+
+	subs subscriptions
+}
+
+func deriveKeys(masterKey string) (publicKey, privateKey [32]byte) {
+	deriver := hkdf.New(sha256.New, []byte(masterKey), nil, []byte("NOTIFIER_KEY"))
+	pub, priv, err := box.GenerateKey(deriver)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return *pub, *priv
+}
+
+func NewPollServer(masterKey string, db DB) *PollServer {
+	ps := &PollServer{db: db}
+	ps.publicKey, ps.privateKey = deriveKeys(masterKey)
+	return ps
+}
+
+// PubKey returns the public key for Config.PublicKey of the server with the
+// given master key.
+func PubKey(masterKey string) [32]byte {
+	publicKey, _ := deriveKeys(masterKey)
+	return publicKey
+}
+
+func (ps *PollServer) Run(listenAddr string) error {
+	lis, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return err
+	}
+	s := grpc.NewServer()
+	pb.RegisterNotifierServer(s, ps)
+	return s.Serve(lis)
 }
 
 func (ps *PollServer) FetchNotifications(ctx context.Context, req *pb.FetchRequest) (*pb.FetchResponse, error) {
-	resp := &pb.FetchResponse{}
 	var deaddropID DeadDropID
 	copy(deaddropID[:], req.GetDeaddropId()) // check len
-	lastRead := req.GetLastRead()
+	continuation, err := decodeCursor(req.GetContinuation())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	notifications, err := ps.fetch(deaddropID, req.GetAckCursor())
+	if err != nil {
+		return nil, err
+	}
 
+	resp := &pb.FetchResponse{}
+	maxResults := capLimit(int(req.GetMaxResults()), maxFetchResults)
+	maxBytes := capLimit(int(req.GetMaxBytes()), maxFetchBytes)
+	size := 0
+	for _, n := range notifications {
+		seq, err := decodeCursor(n.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if seq <= continuation {
+			continue
+		}
+		size += proto.Size(n)
+		if len(resp.Notifications) == maxResults || (len(resp.Notifications) > 0 && size > maxBytes) {
+			resp.HasMore = true
+			resp.Continuation = resp.Notifications[len(resp.Notifications)-1].Cursor
+			break
+		}
+		resp.Notifications = append(resp.Notifications, n)
+	}
+	return resp, nil
+}
+
+// capLimit returns limit, or max if limit is 0 or larger than max.
+func capLimit(limit, max int) int {
+	if limit <= 0 || limit > max {
+		return max
+	}
+	return limit
+}
+
+// fetch deletes the notifications up to ackCursor, and returns the rest.
+func (ps *PollServer) fetch(deaddropID DeadDropID, ackCursor []byte) ([]*pb.Notification, error) {
+	ack, err := decodeCursor(ackCursor)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	var notifications []*pb.Notification
 	if err := ps.db.Fetch(deaddropID, func(messages []*pb.Notification) (dropPrefix int, err error) {
 		startIdx := 0
-		for i, msg := range messages {
-			if len(lastRead) > 0 && bytes.Equal(lastRead, msg.Contents[:len(lastRead)]) {
-				startIdx = i + 1
+		for startIdx < len(messages) {
+			seq, err := decodeCursor(messages[startIdx].Cursor)
+			if err != nil {
+				return 0, err
+			}
+			if seq > ack {
 				break
 			}
+			startIdx++
 		}
-		resp.Notifications = messages[startIdx:]
+		notifications = messages[startIdx:]
 		return startIdx, nil
 	}); err != nil {
 		return nil, err
 	}
-	return resp, nil
+	return notifications, nil
 }
 
 func (ps *PollServer) PostNotificationV1(ctx context.Context, req *pb.PostRequestV1) (*pb.Empty, error) {
 	hint, deaddropID, err := ps.unsealAddressV1(req.GetSealedAddress())
 	if err != nil {
-		return nil, err
+		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 	n := &pb.Notification{
 		Hint:     uint32(hint),
@@ -78,6 +187,7 @@ func (ps *PollServer) PostNotificationV1(ctx context.Context, req *pb.PostReques
 	if err := ps.db.Put(deaddropID, n); err != nil {
 		return nil, err
 	}
+	ps.subs.publish(deaddropID)
 	return &pb.Empty{}, nil
 }
 
@@ -105,39 +215,92 @@ func (nc *NotifierClient) Notify(ctx context.Context, address []byte, msg []byte
 	return err
 }
 
-const LastReadLength = 2
-
 func NewRandomDeadDropClient(conf *Config) (*DeadDropClient, error) {
-	dcl := &DeadDropClient{conf: conf}
+	var address DeadDropID
+	if _, err := io.ReadFull(cryptorand.Reader, address[:]); err != nil {
+		return nil, err
+	}
+	return NewDeadDropClient(conf, address, nil)
+}
+
+// NewDeadDropClient resumes polling address after cursor, as returned by
+// DeadDropClient.Cursor.
+func NewDeadDropClient(conf *Config, address DeadDropID, cursor []byte) (*DeadDropClient, error) {
+	dcl := &DeadDropClient{conf: conf, address: address, cursor: cursor}
 	var err error
 	dcl.stub, err = conf.DialService(context.TODO())
 	if err != nil {
 		return nil, err
 	}
-	if _, err := io.ReadFull(cryptorand.Reader, dcl.address[:]); err != nil {
-		return nil, err
-	}
 	return dcl, nil
 }
 
 type DeadDropClient struct {
 	conf    *Config
 	stub    pb.NotifierClient
-	address [IDSize]byte
+	address DeadDropID
 
-	lastReceived [LastReadLength]byte
+	// cursor of the last notification returned by Poll or Subscribe
+	cursor []byte
+	mu     sync.Mutex
 }
 
+func (dcl *DeadDropClient) Address() DeadDropID {
+	return dcl.address
+}
+
+// Cursor returns the position up to which notifications have been received.
+// Store it to resume with NewDeadDropClient.
+func (dcl *DeadDropClient) Cursor() []byte {
+	dcl.mu.Lock()
+	defer dcl.mu.Unlock()
+	return dcl.cursor
+}
+
+func (dcl *DeadDropClient) setCursor(cursor []byte) {
+	dcl.mu.Lock()
+	dcl.cursor = cursor
+	dcl.mu.Unlock()
+}
+
+// Poll returns the notifications after the cursor, acknowledging all
+// notifications returned by previous calls. It returns only the first page
+// if there are many notifications, see PollAll.
 func (dcl *DeadDropClient) Poll(ctx context.Context) ([]*pb.Notification, error) {
 	resp, err := dcl.stub.FetchNotifications(ctx, &pb.FetchRequest{
 		DeaddropId: dcl.address[:],
-		LastRead:   dcl.lastReceived[:],
+		AckCursor:  dcl.Cursor(),
 	})
 	if err != nil {
 		return nil, err
 	}
 	if len(resp.Notifications) > 0 {
-		copy(dcl.lastReceived[:], resp.Notifications[len(resp.Notifications)-1].Contents)
+		dcl.setCursor(resp.Notifications[len(resp.Notifications)-1].Cursor)
 	}
 	return resp.Notifications, nil
 }
+
+// PollAll is like Poll, but fetches all pages. Nothing is acknowledged
+// until the next call, so no notifications are lost if it fails halfway.
+func (dcl *DeadDropClient) PollAll(ctx context.Context) ([]*pb.Notification, error) {
+	req := &pb.FetchRequest{
+		DeaddropId: dcl.address[:],
+		AckCursor:  dcl.Cursor(),
+	}
+	var notifications []*pb.Notification
+	for {
+		resp, err := dcl.stub.FetchNotifications(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, resp.Notifications...)
+		if !resp.HasMore {
+			break
+		}
+		req.Continuation = resp.Continuation
+	}
+	if len(notifications) > 0 {
+		dcl.setCursor(notifications[len(notifications)-1].Cursor)
+	}
+	return notifications, nil
+}
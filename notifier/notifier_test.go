@@ -0,0 +1,230 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"github.com/yunwilliamyu/contact-trace-mixnet/notifier/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"testing"
+	"time"
+)
+
+// testServer serves ps over an in-memory connection and returns a client
+// for it.
+func testServer(t *testing.T, ps *PollServer) (pb.NotifierClient, func()) {
+	lis := bufconn.Listen(1 << 16)
+	s := grpc.NewServer()
+	pb.RegisterNotifierServer(s, ps)
+	go s.Serve(lis)
+	cc, err := grpc.Dial("bufconn", grpc.WithInsecure(), grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return lis.Dial()
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pb.NewNotifierClient(cc), func() {
+		cc.Close()
+		s.Stop()
+	}
+}
+
+func TestFetchNotificationsAck(t *testing.T) {
+	ps := &PollServer{db: &InMemoryDB{}}
+	var id DeadDropID
+	fetch := func(ack []byte) []*pb.Notification {
+		resp, err := ps.FetchNotifications(context.Background(), &pb.FetchRequest{DeaddropId: id[:], AckCursor: ack})
+		if err != nil {
+			t.Fatalf("FetchNotifications: %s", err.Error())
+		}
+		return resp.Notifications
+	}
+
+	// contents that are empty or share a prefix must not confuse the cursor
+	for _, contents := range []string{"", "ab", "abc", "ab"} {
+		if err := ps.db.Put(id, &pb.Notification{Contents: []byte(contents)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := fetch(nil)
+	if len(got) != 4 {
+		t.Fatalf("got %d notifications, want 4", len(got))
+	}
+	got = fetch(got[1].Cursor)
+	if len(got) != 2 || string(got[0].Contents) != "abc" {
+		t.Fatalf("got %v after acknowledging the second notification", got)
+	}
+	// acknowledging an old cursor again is harmless
+	if got := fetch(nil); len(got) != 2 {
+		t.Errorf("got %d notifications, want 2", len(got))
+	}
+	if got := fetch(got[1].Cursor); len(got) != 0 {
+		t.Errorf("got %d notifications after acknowledging all, want 0", len(got))
+	}
+
+	if _, err := ps.FetchNotifications(context.Background(), &pb.FetchRequest{DeaddropId: id[:], AckCursor: []byte{1}}); err == nil {
+		t.Error("FetchNotifications accepted an invalid cursor")
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	conf := &Config{PublicKey: PubKey("test")}
+	ps := NewPollServer("test", &InMemoryDB{})
+	stub, cleanup := testServer(t, ps)
+	defer cleanup()
+	dcl := &DeadDropClient{conf: conf, stub: stub}
+	dcl.address[0] = 1
+	post := func(i int) {
+		if _, err := stub.PostNotificationV1(context.Background(), &pb.PostRequestV1{
+			SealedAddress: dcl.MakeAddressV1(uint16(i)),
+			Contents:      []byte(fmt.Sprintf("message %d", i)),
+		}); err != nil {
+			t.Fatalf("PostNotificationV1: %s", err.Error())
+		}
+	}
+	receive := func(notifications <-chan *pb.Notification, want ...int) {
+		t.Helper()
+		for _, i := range want {
+			select {
+			case n := <-notifications:
+				if n.Hint != uint32(i) {
+					t.Fatalf("received hint %d, want %d", n.Hint, i)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for notification %d", i)
+			}
+		}
+	}
+
+	post(0)
+	post(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	notifications, err := dcl.Subscribe(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receive(notifications, 0, 1)
+	post(2)
+	receive(notifications, 2)
+	cancel()
+	for range notifications {
+	}
+
+	// resubscribing acknowledges what was received, and resumes after it
+	post(3)
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	notifications, err = dcl.Subscribe(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receive(notifications, 3)
+	got, err := dcl.Poll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("Poll returned %d notifications, want 0", len(got))
+	}
+}
+
+func TestSubscribeConcurrentPosts(t *testing.T) {
+	conf := &Config{PublicKey: PubKey("test")}
+	ps := NewPollServer("test", &InMemoryDB{})
+	stub, cleanup := testServer(t, ps)
+	defer cleanup()
+	dcl := &DeadDropClient{conf: conf, stub: stub}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	notifications, err := dcl.Subscribe(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const count = 100
+	errs := make(chan error, count)
+	for i := 0; i < count; i++ {
+		go func(i int) {
+			_, err := stub.PostNotificationV1(context.Background(), &pb.PostRequestV1{
+				SealedAddress: dcl.MakeAddressV1(uint16(i)),
+			})
+			errs <- err
+		}(i)
+	}
+	for i := 0; i < count; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// notifications published out of order must neither be skipped nor
+	// reordered
+	seen := make(map[uint32]bool)
+	var last uint64
+	for len(seen) < count {
+		select {
+		case n := <-notifications:
+			seq, err := decodeCursor(n.Cursor)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if seq <= last {
+				t.Fatalf("received cursor %d after %d", seq, last)
+			}
+			last = seq
+			seen[n.Hint] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out after %d of %d notifications", len(seen), count)
+		}
+	}
+}
+
+func TestFetchNotificationsPages(t *testing.T) {
+	conf := &Config{PublicKey: PubKey("test")}
+	ps := NewPollServer("test", &InMemoryDB{})
+	stub, cleanup := testServer(t, ps)
+	defer cleanup()
+	dcl := &DeadDropClient{conf: conf, stub: stub}
+	for i := 0; i < 10; i++ {
+		if err := ps.db.Put(dcl.address, notification(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req := &pb.FetchRequest{DeaddropId: dcl.address[:], MaxResults: 4}
+	var pages []int
+	for {
+		resp, err := ps.FetchNotifications(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, len(resp.Notifications))
+		if !resp.HasMore {
+			break
+		}
+		req.Continuation = resp.Continuation
+	}
+	if fmt.Sprint(pages) != "[4 4 2]" {
+		t.Errorf("got pages of %v notifications, want [4 4 2]", pages)
+	}
+
+	// a notification larger than max_bytes is still returned
+	resp, err := ps.FetchNotifications(context.Background(), &pb.FetchRequest{DeaddropId: dcl.address[:], MaxBytes: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Notifications) != 1 || !resp.HasMore {
+		t.Errorf("got %d notifications (has_more %v), want 1 with more", len(resp.Notifications), resp.HasMore)
+	}
+
+	// the pages above acknowledged nothing
+	got, err := dcl.PollAll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkHints(t, got, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9)
+	if got, err := dcl.PollAll(context.Background()); err != nil || len(got) != 0 {
+		t.Errorf("second PollAll returned %d notifications (error %v), want 0", len(got), err)
+	}
+}
@@ -103,8 +103,18 @@ func (m *PostRequestV1) GetContents() []byte {
 }
 
 type FetchRequest struct {
-	DeaddropId           []byte   `protobuf:"bytes,1,opt,name=deaddrop_id,json=deaddropId,proto3" json:"deaddrop_id,omitempty"`
-	LastRead             []byte   `protobuf:"bytes,2,opt,name=last_read,json=lastRead,proto3" json:"last_read,omitempty"`
+	DeaddropId []byte `protobuf:"bytes,1,opt,name=deaddrop_id,json=deaddropId,proto3" json:"deaddrop_id,omitempty"`
+	// cursor of the last notification the client has processed; it and all
+	// notifications before it are deleted
+	AckCursor []byte `protobuf:"bytes,3,opt,name=ack_cursor,json=ackCursor,proto3" json:"ack_cursor,omitempty"`
+	// continuation of the previous response, to fetch its next page without
+	// acknowledging it yet
+	Continuation []byte `protobuf:"bytes,4,opt,name=continuation,proto3" json:"continuation,omitempty"`
+	// limits on the size of the response; the server caps them, and applies
+	// its caps if they are 0. At least one notification is returned even if
+	// it is larger than max_bytes.
+	MaxResults           uint32   `protobuf:"varint,5,opt,name=max_results,json=maxResults,proto3" json:"max_results,omitempty"`
+	MaxBytes             uint32   `protobuf:"varint,6,opt,name=max_bytes,json=maxBytes,proto3" json:"max_bytes,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -142,16 +152,89 @@ func (m *FetchRequest) GetDeaddropId() []byte {
 	return nil
 }
 
-func (m *FetchRequest) GetLastRead() []byte {
+func (m *FetchRequest) GetAckCursor() []byte {
+	if m != nil {
+		return m.AckCursor
+	}
+	return nil
+}
+
+func (m *FetchRequest) GetContinuation() []byte {
+	if m != nil {
+		return m.Continuation
+	}
+	return nil
+}
+
+func (m *FetchRequest) GetMaxResults() uint32 {
+	if m != nil {
+		return m.MaxResults
+	}
+	return 0
+}
+
+func (m *FetchRequest) GetMaxBytes() uint32 {
+	if m != nil {
+		return m.MaxBytes
+	}
+	return 0
+}
+
+type SubscribeRequest struct {
+	DeaddropId           []byte   `protobuf:"bytes,1,opt,name=deaddrop_id,json=deaddropId,proto3" json:"deaddrop_id,omitempty"`
+	AckCursor            []byte   `protobuf:"bytes,2,opt,name=ack_cursor,json=ackCursor,proto3" json:"ack_cursor,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SubscribeRequest) Reset()         { *m = SubscribeRequest{} }
+func (m *SubscribeRequest) String() string { return proto.CompactTextString(m) }
+func (*SubscribeRequest) ProtoMessage()    {}
+func (*SubscribeRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f91b6e59af44fd9d, []int{3}
+}
+
+func (m *SubscribeRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SubscribeRequest.Unmarshal(m, b)
+}
+func (m *SubscribeRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SubscribeRequest.Marshal(b, m, deterministic)
+}
+func (m *SubscribeRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SubscribeRequest.Merge(m, src)
+}
+func (m *SubscribeRequest) XXX_Size() int {
+	return xxx_messageInfo_SubscribeRequest.Size(m)
+}
+func (m *SubscribeRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_SubscribeRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SubscribeRequest proto.InternalMessageInfo
+
+func (m *SubscribeRequest) GetDeaddropId() []byte {
+	if m != nil {
+		return m.DeaddropId
+	}
+	return nil
+}
+
+func (m *SubscribeRequest) GetAckCursor() []byte {
 	if m != nil {
-		return m.LastRead
+		return m.AckCursor
 	}
 	return nil
 }
 
 type Notification struct {
-	Hint                 uint32   `protobuf:"varint,1,opt,name=hint,proto3" json:"hint,omitempty"`
-	Contents             []byte   `protobuf:"bytes,2,opt,name=contents,proto3" json:"contents,omitempty"`
+	Hint     uint32 `protobuf:"varint,1,opt,name=hint,proto3" json:"hint,omitempty"`
+	Contents []byte `protobuf:"bytes,2,opt,name=contents,proto3" json:"contents,omitempty"`
+	// unix time in seconds at which the notification was stored
+	ReceivedAt int64 `protobuf:"varint,3,opt,name=received_at,json=receivedAt,proto3" json:"received_at,omitempty"`
+	// opaque position in the dead drop, assigned by the server; it increases
+	// with every notification stored in the dead drop
+	Cursor               []byte   `protobuf:"bytes,4,opt,name=cursor,proto3" json:"cursor,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -161,7 +244,7 @@ func (m *Notification) Reset()         { *m = Notification{} }
 func (m *Notification) String() string { return proto.CompactTextString(m) }
 func (*Notification) ProtoMessage()    {}
 func (*Notification) Descriptor() ([]byte, []int) {
-	return fileDescriptor_f91b6e59af44fd9d, []int{3}
+	return fileDescriptor_f91b6e59af44fd9d, []int{4}
 }
 
 func (m *Notification) XXX_Unmarshal(b []byte) error {
@@ -196,18 +279,36 @@ func (m *Notification) GetContents() []byte {
 	return nil
 }
 
+func (m *Notification) GetReceivedAt() int64 {
+	if m != nil {
+		return m.ReceivedAt
+	}
+	return 0
+}
+
+func (m *Notification) GetCursor() []byte {
+	if m != nil {
+		return m.Cursor
+	}
+	return nil
+}
+
 type FetchResponse struct {
-	Notifications        []*Notification `protobuf:"bytes,1,rep,name=notifications,proto3" json:"notifications,omitempty"`
-	XXX_NoUnkeyedLiteral struct{}        `json:"-"`
-	XXX_unrecognized     []byte          `json:"-"`
-	XXX_sizecache        int32           `json:"-"`
+	Notifications []*Notification `protobuf:"bytes,1,rep,name=notifications,proto3" json:"notifications,omitempty"`
+	// whether there are more notifications after these
+	HasMore bool `protobuf:"varint,2,opt,name=has_more,json=hasMore,proto3" json:"has_more,omitempty"`
+	// set if has_more, for FetchRequest.continuation
+	Continuation         []byte   `protobuf:"bytes,3,opt,name=continuation,proto3" json:"continuation,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
 func (m *FetchResponse) Reset()         { *m = FetchResponse{} }
 func (m *FetchResponse) String() string { return proto.CompactTextString(m) }
 func (*FetchResponse) ProtoMessage()    {}
 func (*FetchResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_f91b6e59af44fd9d, []int{4}
+	return fileDescriptor_f91b6e59af44fd9d, []int{5}
 }
 
 func (m *FetchResponse) XXX_Unmarshal(b []byte) error {
@@ -235,10 +336,25 @@ func (m *FetchResponse) GetNotifications() []*Notification {
 	return nil
 }
 
+func (m *FetchResponse) GetHasMore() bool {
+	if m != nil {
+		return m.HasMore
+	}
+	return false
+}
+
+func (m *FetchResponse) GetContinuation() []byte {
+	if m != nil {
+		return m.Continuation
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterType((*Empty)(nil), "pb.Empty")
 	proto.RegisterType((*PostRequestV1)(nil), "pb.PostRequestV1")
 	proto.RegisterType((*FetchRequest)(nil), "pb.FetchRequest")
+	proto.RegisterType((*SubscribeRequest)(nil), "pb.SubscribeRequest")
 	proto.RegisterType((*Notification)(nil), "pb.Notification")
 	proto.RegisterType((*FetchResponse)(nil), "pb.FetchResponse")
 }
@@ -246,25 +362,36 @@ func init() {
 func init() { proto.RegisterFile("pb/notifier.proto", fileDescriptor_f91b6e59af44fd9d) }
 
 var fileDescriptor_f91b6e59af44fd9d = []byte{
-	// 286 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x7c, 0x91, 0xc1, 0x4b, 0x33, 0x31,
-	0x10, 0xc5, 0xdb, 0x7e, 0x9f, 0xda, 0x4e, 0xbb, 0x62, 0xe7, 0x54, 0xd6, 0x83, 0xb2, 0x20, 0xf4,
-	0xb4, 0xd2, 0x2a, 0x5e, 0x04, 0xc1, 0x83, 0x8a, 0x20, 0x45, 0x72, 0xe8, 0x75, 0xc9, 0x6e, 0x46,
-	0xba, 0x50, 0x93, 0xb8, 0x19, 0x0f, 0x1e, 0xfc, 0xdf, 0x25, 0xd9, 0xad, 0xa4, 0x17, 0x6f, 0xc9,
-	0xe3, 0xe5, 0x37, 0x6f, 0x5e, 0x60, 0x6a, 0xcb, 0x4b, 0x6d, 0xb8, 0x7e, 0xab, 0xa9, 0xc9, 0x6d,
-	0x63, 0xd8, 0xe0, 0xc0, 0x96, 0xd9, 0x11, 0x1c, 0x3c, 0xbc, 0x5b, 0xfe, 0xca, 0x04, 0x24, 0xaf,
-	0xc6, 0xb1, 0xa0, 0x8f, 0x4f, 0x72, 0xbc, 0x5e, 0xe0, 0x05, 0x1c, 0x3b, 0x92, 0x5b, 0x52, 0x85,
-	0x54, 0xaa, 0x21, 0xe7, 0x66, 0xfd, 0xf3, 0xfe, 0x7c, 0x22, 0x92, 0x56, 0xbd, 0x6f, 0x45, 0x4c,
-	0x61, 0x58, 0x19, 0xcd, 0xa4, 0xd9, 0xcd, 0x06, 0xc1, 0xf0, 0x7b, 0xcf, 0x5e, 0x60, 0xf2, 0x48,
-	0x5c, 0x6d, 0x3a, 0x28, 0x9e, 0xc1, 0x58, 0x91, 0xa7, 0x19, 0x5b, 0xd4, 0xaa, 0xe3, 0xc1, 0x4e,
-	0x7a, 0x56, 0x78, 0x0a, 0xa3, 0xad, 0x74, 0x5c, 0x34, 0x24, 0xd5, 0x8e, 0xe6, 0x05, 0x41, 0x52,
-	0x65, 0x77, 0x30, 0x59, 0x85, 0x05, 0x2a, 0xc9, 0xb5, 0xd1, 0x88, 0xf0, 0x7f, 0x53, 0x6b, 0x0e,
-	0x98, 0x44, 0x84, 0xf3, 0x9f, 0x69, 0x9e, 0x20, 0xe9, 0xd2, 0x38, 0x6b, 0xb4, 0x23, 0xbc, 0x81,
-	0x44, 0x47, 0x40, 0xbf, 0xe0, 0xbf, 0xf9, 0x78, 0x79, 0x92, 0xdb, 0x32, 0x8f, 0x27, 0x89, 0x7d,
-	0xdb, 0xf2, 0x1b, 0x86, 0xab, 0xae, 0x49, 0xbc, 0x06, 0xf4, 0xb5, 0xc5, 0xf6, 0xf5, 0x02, 0xa7,
-	0x1e, 0xb1, 0x57, 0x67, 0x3a, 0xf2, 0x52, 0x5b, 0x75, 0x0f, 0x6f, 0x01, 0x43, 0x94, 0xf8, 0x99,
-	0xc3, 0x30, 0x38, 0x2e, 0x2c, 0x9d, 0x46, 0x4a, 0x1b, 0x3a, 0xeb, 0x95, 0x87, 0xe1, 0xf7, 0xae,
-	0x7e, 0x02, 0x00, 0x00, 0xff, 0xff, 0x9d, 0x62, 0x53, 0xad, 0xd2, 0x01, 0x00, 0x00,
+	// 450 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x53, 0x5d, 0x8b, 0xd3, 0x40,
+	0x14, 0x6d, 0x9a, 0x6e, 0x37, 0xbd, 0xdb, 0x48, 0x7b, 0x91, 0x25, 0x56, 0xc4, 0x65, 0x40, 0xe8,
+	0x53, 0x75, 0x57, 0xf1, 0xc5, 0xa7, 0xf5, 0x0b, 0x14, 0x2c, 0x32, 0xc2, 0xbe, 0x86, 0x49, 0x72,
+	0xa5, 0x61, 0x9b, 0x4c, 0x9c, 0x99, 0x68, 0xf7, 0x0f, 0xf8, 0xbf, 0xc4, 0x3f, 0x26, 0x33, 0xc9,
+	0x2e, 0x49, 0x0b, 0x3e, 0xec, 0x5b, 0xee, 0x99, 0xcb, 0xb9, 0xe7, 0xde, 0x73, 0x02, 0xf3, 0x2a,
+	0x79, 0x5e, 0x4a, 0x93, 0x7f, 0xcf, 0x49, 0xad, 0x2a, 0x25, 0x8d, 0xc4, 0x61, 0x95, 0xb0, 0x63,
+	0x38, 0xfa, 0x50, 0x54, 0xe6, 0x86, 0x71, 0x08, 0xbf, 0x4a, 0x6d, 0x38, 0xfd, 0xa8, 0x49, 0x9b,
+	0xab, 0x73, 0x7c, 0x06, 0x0f, 0x34, 0x89, 0x2d, 0x65, 0xb1, 0xc8, 0x32, 0x45, 0x5a, 0x47, 0xde,
+	0x99, 0xb7, 0x9c, 0xf2, 0xb0, 0x41, 0x2f, 0x1b, 0x10, 0x17, 0x10, 0xa4, 0xb2, 0x34, 0x54, 0x1a,
+	0x1d, 0x0d, 0x5d, 0xc3, 0x5d, 0xcd, 0xfe, 0x78, 0x30, 0xfd, 0x48, 0x26, 0xdd, 0xb4, 0xac, 0xf8,
+	0x14, 0x4e, 0x32, 0xb2, 0x74, 0xb2, 0x8a, 0xf3, 0xac, 0x25, 0x84, 0x5b, 0xe8, 0x53, 0x86, 0x4f,
+	0x00, 0x44, 0x7a, 0x1d, 0xa7, 0xb5, 0xd2, 0x52, 0x45, 0xbe, 0x7b, 0x9f, 0x88, 0xf4, 0xfa, 0x9d,
+	0x03, 0x90, 0xc1, 0xd4, 0x92, 0xe7, 0x65, 0x2d, 0x4c, 0x2e, 0xcb, 0x68, 0xe4, 0x1a, 0x7a, 0x98,
+	0x9d, 0x51, 0x88, 0x5d, 0xac, 0x48, 0xd7, 0x5b, 0xa3, 0xa3, 0xa3, 0x33, 0x6f, 0x19, 0x72, 0x28,
+	0xc4, 0x8e, 0x37, 0x08, 0x3e, 0x86, 0x89, 0x6d, 0x48, 0x6e, 0x0c, 0xe9, 0x68, 0xec, 0x9e, 0x83,
+	0x42, 0xec, 0xde, 0xda, 0xfa, 0xf3, 0x28, 0x18, 0xce, 0x7c, 0x3e, 0xd9, 0x0a, 0x6d, 0x62, 0x45,
+	0x22, 0x63, 0x1c, 0x66, 0xdf, 0xea, 0x44, 0xa7, 0x2a, 0x4f, 0xe8, 0x9e, 0x6b, 0x0c, 0xf7, 0xd6,
+	0x60, 0xbf, 0x60, 0xba, 0x76, 0x56, 0xa4, 0x8d, 0x64, 0x84, 0xd1, 0x26, 0x2f, 0x8d, 0x23, 0x0a,
+	0xb9, 0xfb, 0xfe, 0xdf, 0x5d, 0xed, 0x7c, 0x45, 0x29, 0xe5, 0x3f, 0xad, 0x39, 0xc6, 0x9d, 0xc9,
+	0xe7, 0x70, 0x0b, 0x5d, 0x1a, 0x3c, 0x85, 0x71, 0x3b, 0xbb, 0xb9, 0x50, 0x5b, 0xb1, 0xdf, 0x1e,
+	0x84, 0xad, 0x21, 0xba, 0x92, 0xa5, 0x26, 0x7c, 0x0d, 0x61, 0xd9, 0x91, 0x62, 0x4d, 0xf6, 0x97,
+	0x27, 0x17, 0xb3, 0x55, 0x95, 0xac, 0xba, 0x1a, 0x79, 0xbf, 0x0d, 0x1f, 0x41, 0xb0, 0x11, 0x3a,
+	0x2e, 0xa4, 0x22, 0x27, 0x2f, 0xe0, 0xc7, 0x1b, 0xa1, 0xbf, 0x48, 0x45, 0x07, 0x26, 0xf9, 0x87,
+	0x26, 0x5d, 0xfc, 0xf5, 0x20, 0x58, 0xb7, 0x69, 0xc4, 0x57, 0x80, 0x36, 0x7a, 0xdd, 0x71, 0x57,
+	0xe7, 0x38, 0xb7, 0x12, 0x7a, 0x91, 0x5c, 0x4c, 0x2c, 0xd4, 0xc4, 0x75, 0x80, 0x6f, 0x00, 0xdd,
+	0x2a, 0xeb, 0x9e, 0x2e, 0x27, 0xbc, 0x9b, 0xb9, 0xc5, 0xbc, 0x83, 0x34, 0x4b, 0xb3, 0x01, 0xbe,
+	0x87, 0xd3, 0x3b, 0x57, 0xfb, 0x04, 0x0f, 0x6d, 0xfb, 0xbe, 0xe3, 0x8b, 0x83, 0x7b, 0xb0, 0xc1,
+	0x0b, 0x2f, 0x19, 0xbb, 0xff, 0xe8, 0xe5, 0xbf, 0x01, 0x00, 0x4e, 0xb0, 0xdd, 0x4b, 0x5c, 0x03,
+	0x00, 0x00,
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -281,6 +408,9 @@ const _ = grpc.SupportPackageIsVersion4
 type NotifierClient interface {
 	PostNotificationV1(ctx context.Context, in *PostRequestV1, opts ...grpc.CallOption) (*Empty, error)
 	FetchNotifications(ctx context.Context, in *FetchRequest, opts ...grpc.CallOption) (*FetchResponse, error)
+	// SubscribeNotifications acknowledges like FetchNotifications, then sends
+	// the notifications after ack_cursor, followed by new ones as they arrive.
+	SubscribeNotifications(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (Notifier_SubscribeNotificationsClient, error)
 }
 
 type notifierClient struct {
@@ -309,10 +439,45 @@ func (c *notifierClient) FetchNotifications(ctx context.Context, in *FetchReques
 	return out, nil
 }
 
+func (c *notifierClient) SubscribeNotifications(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (Notifier_SubscribeNotificationsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Notifier_serviceDesc.Streams[0], "/pb.Notifier/SubscribeNotifications", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &notifierSubscribeNotificationsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Notifier_SubscribeNotificationsClient interface {
+	Recv() (*Notification, error)
+	grpc.ClientStream
+}
+
+type notifierSubscribeNotificationsClient struct {
+	grpc.ClientStream
+}
+
+func (x *notifierSubscribeNotificationsClient) Recv() (*Notification, error) {
+	m := new(Notification)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // NotifierServer is the server API for Notifier service.
 type NotifierServer interface {
 	PostNotificationV1(context.Context, *PostRequestV1) (*Empty, error)
 	FetchNotifications(context.Context, *FetchRequest) (*FetchResponse, error)
+	// SubscribeNotifications acknowledges like FetchNotifications, then sends
+	// the notifications after ack_cursor, followed by new ones as they arrive.
+	SubscribeNotifications(*SubscribeRequest, Notifier_SubscribeNotificationsServer) error
 }
 
 // UnimplementedNotifierServer can be embedded to have forward compatible implementations.
@@ -325,6 +490,9 @@ func (*UnimplementedNotifierServer) PostNotificationV1(ctx context.Context, req
 func (*UnimplementedNotifierServer) FetchNotifications(ctx context.Context, req *FetchRequest) (*FetchResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method FetchNotifications not implemented")
 }
+func (*UnimplementedNotifierServer) SubscribeNotifications(req *SubscribeRequest, srv Notifier_SubscribeNotificationsServer) error {
+	return status.Errorf(codes.Unimplemented, "method SubscribeNotifications not implemented")
+}
 
 func RegisterNotifierServer(s *grpc.Server, srv NotifierServer) {
 	s.RegisterService(&_Notifier_serviceDesc, srv)
@@ -366,6 +534,27 @@ func _Notifier_FetchNotifications_Handler(srv interface{}, ctx context.Context,
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Notifier_SubscribeNotifications_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(NotifierServer).SubscribeNotifications(m, &notifierSubscribeNotificationsServer{stream})
+}
+
+type Notifier_SubscribeNotificationsServer interface {
+	Send(*Notification) error
+	grpc.ServerStream
+}
+
+type notifierSubscribeNotificationsServer struct {
+	grpc.ServerStream
+}
+
+func (x *notifierSubscribeNotificationsServer) Send(m *Notification) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 var _Notifier_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "pb.Notifier",
 	HandlerType: (*NotifierServer)(nil),
@@ -379,6 +568,12 @@ var _Notifier_serviceDesc = grpc.ServiceDesc{
 			Handler:    _Notifier_FetchNotifications_Handler,
 		},
 	},
-	Streams:  []grpc.StreamDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "SubscribeNotifications",
+			Handler:       _Notifier_SubscribeNotifications_Handler,
+			ServerStreams: true,
+		},
+	},
 	Metadata: "pb/notifier.proto",
 }
@@ -0,0 +1,237 @@
+package notifier
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/yunwilliamyu/contact-trace-mixnet/notifier/pb"
+	"math"
+	"strings"
+	"time"
+)
+
+// sqlMigrations are applied in order, each at most once. {{blob}} is replaced
+// with the binary column type of the database. Only ever append to this.
+var sqlMigrations = []string{
+	`CREATE TABLE deaddrops (
+		id {{blob}} PRIMARY KEY,
+		next_seq BIGINT NOT NULL
+	);
+	CREATE TABLE notifications (
+		deaddrop_id {{blob}} NOT NULL REFERENCES deaddrops (id),
+		seq BIGINT NOT NULL,
+		hint INTEGER NOT NULL,
+		contents {{blob}} NOT NULL,
+		PRIMARY KEY (deaddrop_id, seq)
+	);`,
+	`ALTER TABLE notifications ADD COLUMN received_at BIGINT NOT NULL DEFAULT 0;
+	CREATE INDEX notifications_received_at ON notifications (received_at);`,
+	`CREATE TABLE seq_floor (floor BIGINT NOT NULL);
+	INSERT INTO seq_floor (floor) SELECT COALESCE(MAX(next_seq), 0) FROM deaddrops;`,
+}
+
+// SQLDB stores dead drops in a SQL database. Both SQLite ("sqlite3") and
+// Postgres ("postgres") are supported; the caller has to link in the driver.
+type SQLDB struct {
+	db *sql.DB
+}
+
+func OpenSQLDB(driverName, dataSourceName string) (*SQLDB, error) {
+	var blobType string
+	switch driverName {
+	case "sqlite3":
+		blobType = "BLOB"
+	case "postgres":
+		blobType = "BYTEA"
+	default:
+		return nil, fmt.Errorf("unsupported SQL driver %q", driverName)
+	}
+	db, err := sql.Open(driverName, dataSourceName)
+	if err != nil {
+		return nil, err
+	}
+	if driverName == "sqlite3" {
+		// SQLite does not allow concurrent writers anyway, and sharing
+		// one connection avoids "database is locked" errors
+		db.SetMaxOpenConns(1)
+	}
+	sdb := &SQLDB{db: db}
+	if err := sdb.migrate(blobType); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return sdb, nil
+}
+
+func (sdb *SQLDB) Close() error {
+	return sdb.db.Close()
+}
+
+func (sdb *SQLDB) migrate(blobType string) error {
+	if _, err := sdb.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`); err != nil {
+		return err
+	}
+	var version int
+	if err := sdb.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return err
+	}
+	for ; version < len(sqlMigrations); version++ {
+		tx, err := sdb.db.Begin()
+		if err != nil {
+			return err
+		}
+		migration := strings.Replace(sqlMigrations[version], "{{blob}}", blobType, -1)
+		// not every driver supports multiple statements per Exec
+		for _, stmt := range strings.Split(migration, ";") {
+			if strings.TrimSpace(stmt) == "" {
+				continue
+			}
+			if _, err := tx.Exec(stmt); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("migration %d: %s", version+1, err.Error())
+			}
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version) VALUES ($1)`, version+1); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sdb *SQLDB) Put(deaddropID DeadDropID, message *pb.Notification) error {
+	stamp(message)
+	tx, err := sdb.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// new dead drops count from the floor (WHERE true keeps SQLite from
+	// parsing ON CONFLICT as a join constraint)
+	if _, err := tx.Exec(`INSERT INTO deaddrops (id, next_seq) SELECT $1, floor FROM seq_floor WHERE true ON CONFLICT (id) DO NOTHING`, deaddropID[:]); err != nil {
+		return err
+	}
+	// the update locks the dead drop's row until commit, so that sequence
+	// numbers are assigned in commit order
+	if _, err := tx.Exec(`UPDATE deaddrops SET next_seq = next_seq + 1 WHERE id = $1`, deaddropID[:]); err != nil {
+		return err
+	}
+	var seq int64
+	if err := tx.QueryRow(`SELECT next_seq FROM deaddrops WHERE id = $1`, deaddropID[:]).Scan(&seq); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO notifications (deaddrop_id, seq, hint, contents, received_at) VALUES ($1, $2, $3, $4, $5)`,
+		deaddropID[:], seq, message.Hint, message.Contents, message.ReceivedAt); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	message.Cursor = encodeCursor(uint64(seq))
+	return nil
+}
+
+func (sdb *SQLDB) Fetch(deaddropID DeadDropID, handler func(messages []*pb.Notification) (dropPrefix int, err error)) error {
+	tx, err := sdb.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the dead drop's row (portable SELECT ... FOR UPDATE), so that
+	// concurrent polls of the same dead drop are serialized and cannot both
+	// hand out and drop the same messages.
+	if _, err := tx.Exec(`UPDATE deaddrops SET next_seq = next_seq WHERE id = $1`, deaddropID[:]); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(`SELECT seq, hint, contents, received_at FROM notifications WHERE deaddrop_id = $1 ORDER BY seq`, deaddropID[:])
+	if err != nil {
+		return err
+	}
+	var seqs []int64
+	var messages []*pb.Notification
+	for rows.Next() {
+		var seq int64
+		n := &pb.Notification{}
+		if err := rows.Scan(&seq, &n.Hint, &n.Contents, &n.ReceivedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		n.Cursor = encodeCursor(uint64(seq))
+		seqs = append(seqs, seq)
+		messages = append(messages, n)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	dropN, err := handler(messages)
+	if err != nil {
+		return err
+	}
+	if dropN > 0 {
+		if _, err := tx.Exec(`DELETE FROM notifications WHERE deaddrop_id = $1 AND seq <= $2`, deaddropID[:], seqs[dropN-1]); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (sdb *SQLDB) After(deaddropID DeadDropID, seq uint64) ([]*pb.Notification, error) {
+	if seq > math.MaxInt64 {
+		return nil, nil
+	}
+	rows, err := sdb.db.Query(`SELECT seq, hint, contents, received_at FROM notifications WHERE deaddrop_id = $1 AND seq > $2 ORDER BY seq`,
+		deaddropID[:], int64(seq))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var messages []*pb.Notification
+	for rows.Next() {
+		var s int64
+		n := &pb.Notification{}
+		if err := rows.Scan(&s, &n.Hint, &n.Contents, &n.ReceivedAt); err != nil {
+			return nil, err
+		}
+		n.Cursor = encodeCursor(uint64(s))
+		messages = append(messages, n)
+	}
+	return messages, rows.Err()
+}
+
+func (sdb *SQLDB) Expire(cutoff time.Time) (ExpiryStats, error) {
+	var stats ExpiryStats
+	tx, err := sdb.db.Begin()
+	if err != nil {
+		return stats, err
+	}
+	defer tx.Rollback()
+
+	if err := tx.QueryRow(`SELECT COUNT(*), COALESCE(SUM(LENGTH(contents)), 0) FROM notifications WHERE received_at < $1`,
+		cutoff.Unix()).Scan(&stats.Notifications, &stats.Bytes); err != nil {
+		return stats, err
+	}
+	if _, err := tx.Exec(`DELETE FROM notifications WHERE received_at < $1`, cutoff.Unix()); err != nil {
+		return stats, err
+	}
+	const emptyDeaddrops = `FROM deaddrops WHERE NOT EXISTS (SELECT 1 FROM notifications WHERE notifications.deaddrop_id = deaddrops.id)`
+	if _, err := tx.Exec(`UPDATE seq_floor SET floor = (SELECT MAX(next_seq) ` + emptyDeaddrops + `)
+		WHERE floor < (SELECT MAX(next_seq) ` + emptyDeaddrops + `)`); err != nil {
+		return stats, err
+	}
+	res, err := tx.Exec(`DELETE ` + emptyDeaddrops)
+	if err != nil {
+		return stats, err
+	}
+	deaddrops, err := res.RowsAffected()
+	if err != nil {
+		return stats, err
+	}
+	stats.DeadDrops = int(deaddrops)
+	return stats, tx.Commit()
+}
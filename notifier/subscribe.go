@@ -0,0 +1,154 @@
+package notifier
+
+import (
+	"context"
+	"github.com/yunwilliamyu/contact-trace-mixnet/notifier/pb"
+	"log"
+	"sync"
+	"time"
+)
+
+// resubscribeDelay is how long DeadDropClient.Subscribe waits before
+// reconnecting a broken stream.
+const resubscribeDelay = 5 * time.Second
+
+// subscriptions wakes up the streams subscribed to a dead drop when a
+// notification is stored in it. Streams read new notifications from the DB
+// rather than from the wake-up, so that they see them in the order of their
+// cursors even if concurrent posts are published out of order. The zero
+// value is ready to use.
+type subscriptions struct {
+	subs map[DeadDropID]map[chan struct{}]struct{}
+	mu   sync.Mutex
+}
+
+func (s *subscriptions) subscribe(deaddropID DeadDropID) chan struct{} {
+	// one pending wake-up is enough, the stream reads everything new
+	ch := make(chan struct{}, 1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subs == nil {
+		s.subs = make(map[DeadDropID]map[chan struct{}]struct{})
+	}
+	if s.subs[deaddropID] == nil {
+		s.subs[deaddropID] = make(map[chan struct{}]struct{})
+	}
+	s.subs[deaddropID][ch] = struct{}{}
+	return ch
+}
+
+func (s *subscriptions) unsubscribe(deaddropID DeadDropID, ch chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subs[deaddropID], ch)
+	if len(s.subs[deaddropID]) == 0 {
+		delete(s.subs, deaddropID)
+	}
+}
+
+func (s *subscriptions) publish(deaddropID DeadDropID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.subs[deaddropID] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (ps *PollServer) SubscribeNotifications(req *pb.SubscribeRequest, stream pb.Notifier_SubscribeNotificationsServer) error {
+	var deaddropID DeadDropID
+	copy(deaddropID[:], req.GetDeaddropId()) // check len
+
+	// subscribe before fetching the backlog, so that nothing stored in
+	// between is missed
+	ch := ps.subs.subscribe(deaddropID)
+	defer ps.subs.unsubscribe(deaddropID, ch)
+
+	backlog, err := ps.fetch(deaddropID, req.GetAckCursor())
+	if err != nil {
+		return err
+	}
+	last, _ := decodeCursor(req.GetAckCursor())
+	send := func(notifications []*pb.Notification) error {
+		for _, n := range notifications {
+			if err := stream.Send(n); err != nil {
+				return err
+			}
+			last, _ = decodeCursor(n.Cursor)
+		}
+		return nil
+	}
+	if err := send(backlog); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-ch:
+			// seqs are assigned and stored in order, so everything up to
+			// the newest one is in the DB by now
+			notifications, err := ps.db.After(deaddropID, last)
+			if err != nil {
+				return err
+			}
+			if err := send(notifications); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (dcl *DeadDropClient) subscribe(ctx context.Context) (pb.Notifier_SubscribeNotificationsClient, error) {
+	return dcl.stub.SubscribeNotifications(ctx, &pb.SubscribeRequest{
+		DeaddropId: dcl.address[:],
+		AckCursor:  dcl.Cursor(),
+	})
+}
+
+// Subscribe returns the notifications after the cursor, followed by new ones
+// as they arrive, acknowledging all notifications returned previously. Broken
+// streams are resumed from the cursor. The channel is closed once ctx is done.
+func (dcl *DeadDropClient) Subscribe(ctx context.Context) (<-chan *pb.Notification, error) {
+	stream, err := dcl.subscribe(ctx)
+	if err != nil {
+		return nil, err
+	}
+	notifications := make(chan *pb.Notification)
+	go func() {
+		defer close(notifications)
+		for {
+			for {
+				n, err := stream.Recv()
+				if err != nil {
+					if ctx.Err() != nil {
+						return
+					}
+					log.Printf("dead drop subscription broke: %s", err.Error())
+					break
+				}
+				select {
+				case notifications <- n:
+					dcl.setCursor(n.Cursor)
+				case <-ctx.Done():
+					return
+				}
+			}
+			for {
+				select {
+				case <-time.After(resubscribeDelay):
+				case <-ctx.Done():
+					return
+				}
+				stream, err = dcl.subscribe(ctx)
+				if err == nil {
+					break
+				}
+				log.Printf("error while resubscribing to dead drop: %s", err.Error())
+			}
+		}
+	}()
+	return notifications, nil
+}
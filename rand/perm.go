@@ -10,7 +10,8 @@ type ReaderSource struct {
 }
 
 func (rs ReaderSource) Int63() int64 {
-	return int64(rs.Uint64())
+	// math/rand requires Int63 to be non-negative
+	return int64(rs.Uint64() &^ (1 << 63))
 }
 
 func (rs ReaderSource) Uint64() uint64 {
@@ -0,0 +1,21 @@
+package rand
+
+import (
+	"bytes"
+	mathrand "math/rand"
+	"testing"
+)
+
+func TestReaderSourceInt63(t *testing.T) {
+	// the top bit is set, which used to make Int63 and Intn negative
+	rs := ReaderSource{Reader: bytes.NewReader(bytes.Repeat([]byte{0x80}, 1024))}
+	if v := rs.Int63(); v < 0 {
+		t.Errorf("Int63 returned %d", v)
+	}
+	rng := mathrand.New(rs)
+	for i := 0; i < 10; i++ {
+		if v := rng.Intn(3); v < 0 || v >= 3 {
+			t.Errorf("Intn(3) returned %d", v)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package store
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	bolt "go.etcd.io/bbolt"
+	"math"
+	"time"
+)
+
+var (
+	mailboxesBucket     = []byte("mailboxes")
+	subscriptionsBucket = []byte("subscriptions")
+)
+
+// BoltDB stores mailboxes on disk. Each mailbox is a bucket of messages keyed
+// by their big-endian ID. Subscriptions are kept in their own bucket.
+type BoltDB struct {
+	db *bolt.DB
+}
+
+func OpenBoltDB(path string) (*BoltDB, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(mailboxesBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(subscriptionsBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltDB{db: db}, nil
+}
+
+func (bdb *BoltDB) Close() error {
+	return bdb.db.Close()
+}
+
+func messageKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
+
+func (bdb *BoltDB) Put(batch []Mail) error {
+	return bdb.Apply(batch, nil)
+}
+
+func (bdb *BoltDB) Apply(batch []Mail, subs []Subscription) error {
+	now := stamp()
+	return bdb.db.Update(func(tx *bolt.Tx) error {
+		if err := put(tx, batch, now); err != nil {
+			return err
+		}
+		return subscribe(tx, subs, now)
+	})
+}
+
+func put(tx *bolt.Tx, batch []Mail, receivedAt int64) error {
+	mailboxes := tx.Bucket(mailboxesBucket)
+	for _, mail := range batch {
+		m, err := mailboxes.CreateBucketIfNotExists(mail.Addr[:])
+		if err != nil {
+			return err
+		}
+		id, err := m.NextSequence()
+		if err != nil {
+			return err
+		}
+		value, err := json.Marshal(&Message{ID: id, Contents: mail.Contents, ReceivedAt: receivedAt})
+		if err != nil {
+			return err
+		}
+		if err := m.Put(messageKey(id), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (bdb *BoltDB) After(addr MailboxAddr, after uint64) ([]*Message, error) {
+	if after == math.MaxUint64 {
+		return nil, nil
+	}
+	var messages []*Message
+	err := bdb.db.View(func(tx *bolt.Tx) error {
+		m := tx.Bucket(mailboxesBucket).Bucket(addr[:])
+		if m == nil {
+			return nil
+		}
+		c := m.Cursor()
+		for k, v := c.Seek(messageKey(after + 1)); k != nil; k, v = c.Next() {
+			msg := &Message{}
+			if err := json.Unmarshal(v, msg); err != nil {
+				return err
+			}
+			messages = append(messages, msg)
+		}
+		return nil
+	})
+	return messages, err
+}
+
+func (bdb *BoltDB) Subscribe(subs []Subscription) error {
+	return bdb.Apply(nil, subs)
+}
+
+func subscribe(tx *bolt.Tx, subs []Subscription, renewedAt int64) error {
+	b := tx.Bucket(subscriptionsBucket)
+	mailboxes := tx.Bucket(mailboxesBucket)
+	for _, sub := range subs {
+		if v := b.Get(sub.key()); v != nil {
+			if err := json.Unmarshal(v, &sub); err != nil {
+				return err
+			}
+		} else if m := mailboxes.Bucket(sub.Addr[:]); m != nil {
+			// mail from before the subscription is not forwarded
+			sub.Marker = m.Sequence()
+		} else {
+			sub.Marker = 0
+		}
+		sub.RenewedAt = renewedAt
+		value, err := json.Marshal(&sub)
+		if err != nil {
+			return err
+		}
+		if err := b.Put(sub.key(), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (bdb *BoltDB) Subscriptions() ([]*Subscription, error) {
+	var subs []*Subscription
+	err := bdb.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(subscriptionsBucket).ForEach(func(_, v []byte) error {
+			sub := &Subscription{}
+			if err := json.Unmarshal(v, sub); err != nil {
+				return err
+			}
+			subs = append(subs, sub)
+			return nil
+		})
+	})
+	return subs, err
+}
+
+func (bdb *BoltDB) SetMarker(sub *Subscription, marker uint64) error {
+	return bdb.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(subscriptionsBucket)
+		v := b.Get(sub.key())
+		if v == nil {
+			// the subscription expired in the meantime
+			return nil
+		}
+		existing := &Subscription{}
+		if err := json.Unmarshal(v, existing); err != nil {
+			return err
+		}
+		if marker <= existing.Marker {
+			return nil
+		}
+		existing.Marker = marker
+		value, err := json.Marshal(existing)
+		if err != nil {
+			return err
+		}
+		return b.Put(sub.key(), value)
+	})
+}
+
+func (bdb *BoltDB) ExpireSubscriptions(cutoff time.Time) (int, error) {
+	n := 0
+	err := bdb.db.Update(func(tx *bolt.Tx) error {
+		n = 0
+		b := tx.Bucket(subscriptionsBucket)
+		var expired [][]byte
+		if err := b.ForEach(func(k, v []byte) error {
+			sub := &Subscription{}
+			if err := json.Unmarshal(v, sub); err != nil {
+				return err
+			}
+			if sub.RenewedAt < cutoff.Unix() {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
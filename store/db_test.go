@@ -0,0 +1,147 @@
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func mail(addr byte, i int) Mail {
+	var m Mail
+	m.Addr[0] = addr
+	m.Contents = []byte(fmt.Sprintf("message %d", i))
+	return m
+}
+
+func checkMessages(t *testing.T, got []*Message, want ...int) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d messages, want %d", len(got), len(want))
+	}
+	for i, msg := range got {
+		if i > 0 && msg.ID <= got[i-1].ID {
+			t.Errorf("ID of message %d does not increase", i)
+		}
+		if wantContents := fmt.Sprintf("message %d", want[i]); string(msg.Contents) != wantContents {
+			t.Errorf("message %d is %q, want %q", i, msg.Contents, wantContents)
+		}
+	}
+}
+
+// testDB checks that db behaves like every DB implementation should. db
+// must be empty.
+func testDB(t *testing.T, db DB) {
+	after := func(addr byte, id uint64) []*Message {
+		t.Helper()
+		var a MailboxAddr
+		a[0] = addr
+		messages, err := db.After(a, id)
+		if err != nil {
+			t.Fatalf("After: %s", err.Error())
+		}
+		return messages
+	}
+
+	checkMessages(t, after(1, 0))
+	if err := db.Put([]Mail{mail(1, 0), mail(2, 100), mail(1, 1)}); err != nil {
+		t.Fatalf("Put: %s", err.Error())
+	}
+	if err := db.Put([]Mail{mail(1, 2)}); err != nil {
+		t.Fatalf("Put: %s", err.Error())
+	}
+
+	all := after(1, 0)
+	checkMessages(t, all, 0, 1, 2)
+	checkMessages(t, after(1, all[0].ID), 1, 2)
+	checkMessages(t, after(1, all[2].ID))
+	checkMessages(t, after(2, 0), 100)
+	if all[0].ReceivedAt == 0 {
+		t.Error("ReceivedAt is not set")
+	}
+
+	// subscriptions
+	subscriptions := func() map[string]*Subscription {
+		t.Helper()
+		subs, err := db.Subscriptions()
+		if err != nil {
+			t.Fatalf("Subscriptions: %s", err.Error())
+		}
+		m := make(map[string]*Subscription)
+		for _, sub := range subs {
+			m[string(sub.RenewalID)] = sub
+		}
+		return m
+	}
+	var a MailboxAddr
+	a[0] = 1
+	if err := db.Subscribe([]Subscription{{Addr: a, RenewalID: []byte("gw1"), ForwardAddr: []byte("addr-gw1")}, {Addr: a, RenewalID: []byte("gw2"), ForwardAddr: []byte("addr-gw2")}}); err != nil {
+		t.Fatalf("Subscribe: %s", err.Error())
+	}
+	subs := subscriptions()
+	if len(subs) != 2 || subs["gw1"] == nil || subs["gw1"].Addr != a || subs["gw1"].RenewedAt == 0 {
+		t.Fatalf("got subscriptions %v", subs)
+	}
+	// new subscriptions start at the last message
+	last := all[2].ID
+	if subs["gw1"].Marker != last || subs["gw2"].Marker != last {
+		t.Errorf("got subscriptions %v, want markers %d", subs, last)
+	}
+	if err := db.SetMarker(subs["gw1"], last+2); err != nil {
+		t.Fatalf("SetMarker: %s", err.Error())
+	}
+	// markers do not go back
+	if err := db.SetMarker(subs["gw1"], last+1); err != nil {
+		t.Fatalf("SetMarker: %s", err.Error())
+	}
+	// renewing keeps the marker
+	var empty MailboxAddr
+	empty[0] = 3
+	if err := db.Subscribe([]Subscription{{Addr: a, RenewalID: []byte("gw1"), ForwardAddr: []byte("resealed")}, {Addr: empty, RenewalID: []byte("gw3"), ForwardAddr: []byte("addr-gw3")}}); err != nil {
+		t.Fatalf("Subscribe: %s", err.Error())
+	}
+	subs = subscriptions()
+	if string(subs["gw1"].ForwardAddr) != "addr-gw1" {
+		t.Errorf("renewal changed the forwarding address to %q", subs["gw1"].ForwardAddr)
+	}
+	if len(subs) != 3 || subs["gw1"].Marker != last+2 || subs["gw2"].Marker != last || subs["gw3"].Marker != 0 {
+		t.Errorf("got subscriptions %v, want markers %d, %d and 0", subs, last+2, last)
+	}
+
+	if n, err := db.ExpireSubscriptions(time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Errorf("ExpireSubscriptions expired %d (error %v), want 0", n, err)
+	}
+	if n, err := db.ExpireSubscriptions(time.Now().Add(time.Hour)); err != nil || n != 3 {
+		t.Errorf("ExpireSubscriptions expired %d (error %v), want 3", n, err)
+	}
+	if subs := subscriptions(); len(subs) != 0 {
+		t.Errorf("got %d subscriptions after expiry", len(subs))
+	}
+	// the subscription is gone, so this is a no-op
+	if err := db.SetMarker(&Subscription{Addr: a, RenewalID: []byte("gw1"), ForwardAddr: []byte("addr-gw1")}, 3); err != nil {
+		t.Fatalf("SetMarker: %s", err.Error())
+	}
+	if subs := subscriptions(); len(subs) != 0 {
+		t.Errorf("SetMarker recreated an expired subscription")
+	}
+}
+
+func TestInMemoryDB(t *testing.T) {
+	testDB(t, &InMemoryDB{})
+}
+
+func TestBoltDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := OpenBoltDB(filepath.Join(dir, "store.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	testDB(t, db)
+}
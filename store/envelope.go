@@ -0,0 +1,126 @@
+package store
+
+import (
+	cryptorand "crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// Envelopes are the messages that leave the last mixnet node. Each one is
+// exactly MixnetServerConfig.MessageLength long:
+//
+//	version (1 byte) + mailbox addr + box(type (1 byte) + payload length (2 bytes) + payload + zero padding)
+//
+// The box is sealed to the store, so that neither the type nor the length of
+// the payload is visible inside the mixnet.
+const EnvelopeVersion = 1
+
+type EnvelopeType byte
+
+const (
+	// Publish deposits the payload in the mailbox.
+	EnvelopePublish EnvelopeType = 1
+	// Subscribe makes the store forward the mail in the mailbox to a dead
+	// drop of the polling gateway. The payload is a renewal ID (see
+	// RenewalIDSize) followed by the sealed dead drop address (see
+	// notifier.DeadDropClient.MakeAddressV1).
+	EnvelopeSubscribe EnvelopeType = 2
+	// ReplyRequest makes the store send mail in the mailbox back through the
+	// mixnet with a reply block, see reply.go.
+	EnvelopeReplyRequest EnvelopeType = 3
+	// Fragment carries a fragment (see mixnet.Fragment) of the mailbox
+	// address followed by mail of any size, which the store deposits once it
+	// has all fragments.
+	EnvelopeFragment EnvelopeType = 4
+)
+
+const (
+	envelopeHeaderSize = 1 + AddrSize
+	boxHeaderSize      = 1 + 2
+)
+
+type Envelope struct {
+	Type    EnvelopeType
+	Addr    MailboxAddr
+	Payload []byte
+}
+
+func NewPublish(addr MailboxAddr, contents []byte) *Envelope {
+	return &Envelope{Type: EnvelopePublish, Addr: addr, Payload: contents}
+}
+
+func NewSubscribe(addr MailboxAddr, renewalID []byte, forwardAddr []byte) *Envelope {
+	payload := append(append([]byte(nil), renewalID...), forwardAddr...)
+	return &Envelope{Type: EnvelopeSubscribe, Addr: addr, Payload: payload}
+}
+
+func NewFragment(addr MailboxAddr, fragment []byte) *Envelope {
+	return &Envelope{Type: EnvelopeFragment, Addr: addr, Payload: fragment}
+}
+
+// MaxPayloadLength returns how long payloads in envelopes of messageLength
+// can be.
+func MaxPayloadLength(messageLength int) int {
+	return messageLength - envelopeHeaderSize - box.AnonymousOverhead - boxHeaderSize
+}
+
+// Seal returns e padded to exactly messageLength, for the store with the
+// given public key.
+func (e *Envelope) Seal(storePublicKey *[32]byte, messageLength int) ([]byte, error) {
+	maxLength := MaxPayloadLength(messageLength)
+	if maxLength < 0 {
+		return nil, fmt.Errorf("message length %d is too short for envelopes", messageLength)
+	}
+	if len(e.Payload) > maxLength || len(e.Payload) > 0xffff {
+		return nil, fmt.Errorf("payload too long: %d bytes, at most %d fit", len(e.Payload), maxLength)
+	}
+	plaintext := make([]byte, boxHeaderSize+maxLength)
+	plaintext[0] = byte(e.Type)
+	binary.BigEndian.PutUint16(plaintext[1:], uint16(len(e.Payload)))
+	copy(plaintext[boxHeaderSize:], e.Payload)
+
+	msg := make([]byte, envelopeHeaderSize, messageLength)
+	msg[0] = EnvelopeVersion
+	copy(msg[1:], e.Addr[:])
+	msg, err := box.SealAnonymous(msg, plaintext, storePublicKey, cryptorand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if len(msg) != messageLength {
+		panic("envelope has the wrong length")
+	}
+	return msg, nil
+}
+
+// OpenEnvelope parses an envelope of messageLength with the keys of the
+// store.
+func OpenEnvelope(publicKey, privateKey *[32]byte, msg []byte, messageLength int) (*Envelope, error) {
+	if len(msg) != messageLength {
+		return nil, fmt.Errorf("envelope of invalid length: %d, expected %d", len(msg), messageLength)
+	}
+	if MaxPayloadLength(messageLength) < 0 {
+		return nil, fmt.Errorf("message length %d is too short for envelopes", messageLength)
+	}
+	if msg[0] != EnvelopeVersion {
+		return nil, fmt.Errorf("unknown envelope version %d", msg[0])
+	}
+	e := &Envelope{}
+	copy(e.Addr[:], msg[1:envelopeHeaderSize])
+	plaintext, ok := box.OpenAnonymous(nil, msg[envelopeHeaderSize:], publicKey, privateKey)
+	if !ok {
+		return nil, fmt.Errorf("cannot decrypt envelope")
+	}
+	e.Type = EnvelopeType(plaintext[0])
+	n := int(binary.BigEndian.Uint16(plaintext[1:]))
+	if boxHeaderSize+n > len(plaintext) {
+		return nil, fmt.Errorf("invalid payload length: %d", n)
+	}
+	e.Payload = plaintext[boxHeaderSize : boxHeaderSize+n]
+	for _, b := range plaintext[boxHeaderSize+n:] {
+		if b != 0 {
+			return nil, fmt.Errorf("invalid padding")
+		}
+	}
+	return e, nil
+}
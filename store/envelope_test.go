@@ -0,0 +1,48 @@
+package store
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEnvelope(t *testing.T) {
+	pub, priv := deriveKeys("test")
+	var addr MailboxAddr
+	addr[0] = 1
+	maxPayload := bytes.Repeat([]byte{1}, MaxPayloadLength(messageLength))
+	for _, e := range []*Envelope{
+		NewPublish(addr, []byte("hello")),
+		NewPublish(addr, nil),
+		NewPublish(addr, maxPayload),
+		NewSubscribe(addr, []byte("id"), []byte("gateway")),
+		NewReplyRequest(addr, 1, []byte("block")),
+	} {
+		msg, err := e.Seal(&pub, messageLength)
+		if err != nil {
+			t.Fatalf("Seal: %s", err.Error())
+		}
+		// types and payload lengths cannot be told apart
+		if len(msg) != messageLength {
+			t.Errorf("envelope is %d bytes long, want %d", len(msg), messageLength)
+		}
+		got, err := OpenEnvelope(&pub, &priv, msg, messageLength)
+		if err != nil {
+			t.Fatalf("OpenEnvelope: %s", err.Error())
+		}
+		if got.Type != e.Type || got.Addr != e.Addr || !bytes.Equal(got.Payload, e.Payload) {
+			t.Errorf("got envelope %+v, want %+v", got, e)
+		}
+
+		msg[0] = EnvelopeVersion + 1
+		if _, err := OpenEnvelope(&pub, &priv, msg, messageLength); err == nil {
+			t.Error("OpenEnvelope accepted an unknown version")
+		}
+	}
+
+	if _, err := NewPublish(addr, append(maxPayload, 1)).Seal(&pub, messageLength); err == nil {
+		t.Error("Seal accepted a payload that is too long")
+	}
+	if _, err := NewPublish(addr, nil).Seal(&pub, 10); err == nil {
+		t.Error("Seal accepted a message length that is too short")
+	}
+}
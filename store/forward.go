@@ -0,0 +1,106 @@
+package store
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Subscription makes the store forward the mail in a mailbox to the polling
+// gateway.
+type Subscription struct {
+	Addr MailboxAddr
+	// chosen by the subscriber to identify renewals, see RenewalIDSize
+	RenewalID []byte
+	// sealed address of a dead drop on the polling gateway, see
+	// notifier.DeadDropClient.MakeAddressV1
+	ForwardAddr []byte
+	// ID of the last message that was forwarded
+	Marker    uint64
+	RenewedAt int64 // unix time in seconds
+}
+
+// RenewalIDSize is the size of renewal IDs. Subscribers pick a random renewal
+// ID for each subscription and send it again to renew the subscription, since
+// the sealed forwarding address differs every time. Reusing it for another
+// mailbox would let the store link the mailboxes.
+const RenewalIDSize = 16
+
+// key identifies the subscription within the DB, so that renewals of a
+// subscription have the same key.
+func (sub *Subscription) key() []byte {
+	return append(sub.Addr[:], sub.RenewalID...)
+}
+
+// Notifier delivers forwarded mail to the polling gateway.
+// *notifier.NotifierClient implements it.
+type Notifier interface {
+	Notify(ctx context.Context, address []byte, msg []byte) error
+}
+
+// Forward sends the mail that arrived after the marker of each subscription,
+// and advances the markers. Markers are only advanced once the mail is
+// delivered, so mail is delivered at least once; a crash can lead to
+// duplicates.
+func (s *Store) Forward(ctx context.Context) error {
+	subs, err := s.db.Subscriptions()
+	if err != nil {
+		return err
+	}
+	for _, sub := range subs {
+		if err := s.forward(ctx, sub); err != nil {
+			// the remaining mail is retried in the next round
+			log.Printf("error while forwarding mail: %s", err.Error())
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (s *Store) forward(ctx context.Context, sub *Subscription) error {
+	messages, err := s.db.After(sub.Addr, sub.Marker)
+	if err != nil {
+		return err
+	}
+	marker := sub.Marker
+	var notifyErr error
+	for _, msg := range messages {
+		if notifyErr = s.Notifier.Notify(ctx, sub.ForwardAddr, msg.Contents); notifyErr != nil {
+			break
+		}
+		marker = msg.ID
+	}
+	if marker != sub.Marker {
+		if err := s.db.SetMarker(sub, marker); err != nil {
+			return err
+		}
+	}
+	return notifyErr
+}
+
+// ExpireSubscriptions deletes subscriptions that were not renewed within
+// s.SubscriptionTTL.
+func (s *Store) ExpireSubscriptions() (int, error) {
+	return s.db.ExpireSubscriptions(time.Now().Add(-s.SubscriptionTTL))
+}
+
+// RunForwarder calls Forward every interval, and expires subscriptions if
+// s.SubscriptionTTL is set.
+func (s *Store) RunForwarder(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if s.SubscriptionTTL > 0 {
+			n, err := s.ExpireSubscriptions()
+			if err != nil {
+				log.Printf("error while expiring subscriptions: %s", err.Error())
+			} else if n > 0 {
+				log.Printf("expired %d subscriptions", n)
+			}
+		}
+		if err := s.Forward(context.Background()); err != nil {
+			log.Printf("error while forwarding mail: %s", err.Error())
+		}
+	}
+}
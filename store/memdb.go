@@ -0,0 +1,121 @@
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+type inMemoryMailbox struct {
+	messages []*Message
+	lastID   uint64
+}
+
+type InMemoryDB struct {
+	db   map[MailboxAddr]*inMemoryMailbox
+	subs map[string]*Subscription
+	mu   sync.Mutex
+}
+
+func (memdb *InMemoryDB) Put(batch []Mail) error {
+	return memdb.Apply(batch, nil)
+}
+
+func (memdb *InMemoryDB) Apply(batch []Mail, subs []Subscription) error {
+	memdb.mu.Lock()
+	defer memdb.mu.Unlock()
+	memdb.put(batch)
+	memdb.subscribe(subs)
+	return nil
+}
+
+func (memdb *InMemoryDB) put(batch []Mail) {
+	if memdb.db == nil {
+		memdb.db = make(map[MailboxAddr]*inMemoryMailbox)
+	}
+	receivedAt := stamp()
+	for _, mail := range batch {
+		m, ok := memdb.db[mail.Addr]
+		if !ok {
+			m = &inMemoryMailbox{}
+			memdb.db[mail.Addr] = m
+		}
+		m.lastID++
+		m.messages = append(m.messages, &Message{ID: m.lastID, Contents: mail.Contents, ReceivedAt: receivedAt})
+	}
+}
+
+func (memdb *InMemoryDB) After(addr MailboxAddr, after uint64) ([]*Message, error) {
+	memdb.mu.Lock()
+	defer memdb.mu.Unlock()
+	m, ok := memdb.db[addr]
+	if !ok {
+		return nil, nil
+	}
+	// IDs are consecutive
+	if after >= uint64(len(m.messages)) {
+		return nil, nil
+	}
+	return append([]*Message(nil), m.messages[after:]...), nil
+}
+
+func (memdb *InMemoryDB) Subscribe(subs []Subscription) error {
+	return memdb.Apply(nil, subs)
+}
+
+func (memdb *InMemoryDB) subscribe(subs []Subscription) {
+	if memdb.subs == nil {
+		memdb.subs = make(map[string]*Subscription)
+	}
+	renewedAt := stamp()
+	for _, sub := range subs {
+		key := string(sub.key())
+		if existing, ok := memdb.subs[key]; ok {
+			existing.RenewedAt = renewedAt
+			continue
+		}
+		s := sub
+		s.RenewalID = append([]byte(nil), sub.RenewalID...)
+		s.ForwardAddr = append([]byte(nil), sub.ForwardAddr...)
+		s.RenewedAt = renewedAt
+		// mail from before the subscription is not forwarded
+		s.Marker = 0
+		if m, ok := memdb.db[sub.Addr]; ok {
+			s.Marker = m.lastID
+		}
+		memdb.subs[key] = &s
+	}
+}
+
+func (memdb *InMemoryDB) Subscriptions() ([]*Subscription, error) {
+	memdb.mu.Lock()
+	defer memdb.mu.Unlock()
+	var subs []*Subscription
+	for _, sub := range memdb.subs {
+		s := *sub
+		subs = append(subs, &s)
+	}
+	return subs, nil
+}
+
+func (memdb *InMemoryDB) SetMarker(sub *Subscription, marker uint64) error {
+	memdb.mu.Lock()
+	defer memdb.mu.Unlock()
+	// the subscription may have expired in the meantime
+	if existing, ok := memdb.subs[string(sub.key())]; ok && marker > existing.Marker {
+		existing.Marker = marker
+	}
+	return nil
+}
+
+func (memdb *InMemoryDB) ExpireSubscriptions(cutoff time.Time) (int, error) {
+	memdb.mu.Lock()
+	defer memdb.mu.Unlock()
+	n := 0
+	for key, sub := range memdb.subs {
+		if sub.RenewedAt < cutoff.Unix() {
+			delete(memdb.subs, key)
+			n++
+		}
+	}
+	return n, nil
+}
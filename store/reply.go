@@ -0,0 +1,113 @@
+package store
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/yunwilliamyu/contact-trace-mixnet/mixnet"
+	"log"
+)
+
+// A reply request carries a reply block (see mixnet.MixnetClient.NewReplyBlock),
+// with which the store sends the first message in the mailbox after a given
+// ID back through the mixnet, without learning who reads the mailbox:
+//
+//	after (8 bytes) + reply block
+//
+// The payload of the reply is
+//
+//	message ID (8 bytes) + contents length (4 bytes) + contents + zero padding
+//
+// with ID 0 if there is no such message. Contents which do not fit are cut
+// off.
+
+const replyHeaderSize = 8 + 4
+
+type replyRequest struct {
+	addr       MailboxAddr
+	after      uint64
+	replyBlock []byte
+}
+
+func NewReplyRequest(addr MailboxAddr, after uint64, replyBlock []byte) *Envelope {
+	payload := make([]byte, 8, 8+len(replyBlock))
+	binary.BigEndian.PutUint64(payload, after)
+	return &Envelope{Type: EnvelopeReplyRequest, Addr: addr, Payload: append(payload, replyBlock...)}
+}
+
+// SealReplyRequest returns a reply request envelope for the mixnet, which
+// makes the store send the first message at addr after the one with ID after
+// with replyBlock.
+func SealReplyRequest(storePublicKey *[32]byte, addr MailboxAddr, after uint64, replyBlock []byte, messageLength int) ([]byte, error) {
+	return NewReplyRequest(addr, after, replyBlock).Seal(storePublicKey, messageLength)
+}
+
+func parseReplyRequest(e *Envelope) (replyRequest, error) {
+	if len(e.Payload) <= 8 {
+		return replyRequest{}, fmt.Errorf("reply request without reply block")
+	}
+	return replyRequest{
+		addr:       e.Addr,
+		after:      binary.BigEndian.Uint64(e.Payload),
+		replyBlock: e.Payload[8:],
+	}, nil
+}
+
+// replyPayload encodes msg, which may be nil, as a reply of messageLength.
+func replyPayload(msg *Message, messageLength int) []byte {
+	payload := make([]byte, messageLength)
+	if msg == nil {
+		return payload
+	}
+	binary.BigEndian.PutUint64(payload, msg.ID)
+	binary.BigEndian.PutUint32(payload[8:], uint32(len(msg.Contents)))
+	copy(payload[replyHeaderSize:], msg.Contents)
+	return payload
+}
+
+// OpenReply parses the payload of a reply (see mixnet.ReplySecret.Open). It
+// returns nil if there was no message, and complete is false if the contents
+// were cut off.
+func OpenReply(payload []byte) (msg *Message, complete bool, err error) {
+	if len(payload) < replyHeaderSize {
+		return nil, false, fmt.Errorf("reply too short: %d bytes", len(payload))
+	}
+	id := binary.BigEndian.Uint64(payload)
+	if id == 0 {
+		return nil, true, nil
+	}
+	n := int(binary.BigEndian.Uint32(payload[8:]))
+	contents := payload[replyHeaderSize:]
+	if n <= len(contents) {
+		return &Message{ID: id, Contents: contents[:n]}, true, nil
+	}
+	return &Message{ID: id, Contents: contents}, false, nil
+}
+
+// sendReplies answers reply requests through the node at s.ReplyAddr.
+// Replies are not retried; the recipient sends another reply block if it does
+// not get one.
+func (s *Store) sendReplies(requests []replyRequest) {
+	if s.ReplyAddr == "" {
+		log.Printf("dropping %d reply requests without ReplyAddr", len(requests))
+		return
+	}
+	var replies [][]byte
+	for _, req := range requests {
+		messages, err := s.db.After(req.addr, req.after)
+		if err != nil {
+			log.Printf("error while reading mailbox: %s", err.Error())
+			continue
+		}
+		var msg *Message
+		if len(messages) > 0 {
+			msg = messages[0]
+		}
+		replies = append(replies, mixnet.WrapReply(req.replyBlock, replyPayload(msg, s.messageLength)))
+	}
+	if len(replies) == 0 {
+		return
+	}
+	if err := mixnet.SendReplies(s.ReplyAddr, replies); err != nil {
+		log.Printf("error while sending replies: %s", err.Error())
+	}
+}
@@ -0,0 +1,96 @@
+package store
+
+import (
+	"bytes"
+	"github.com/yunwilliamyu/contact-trace-mixnet/mixnet"
+	"github.com/yunwilliamyu/contact-trace-mixnet/mixnet/pb"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReplyRequest(t *testing.T) {
+	const replyLength = 200
+	s := NewStore("test", replyLength, &InMemoryDB{})
+	pub := PubKey("test")
+
+	// stands in for the node with idx 0
+	var replies [][]byte
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		mixnet.ServeOnions(rw, req, 0, func(putReq *pb.PutOnionsRequest) error {
+			replies = append(replies, putReq.Msgs...)
+			return nil
+		})
+	}))
+	defer srv.Close()
+	s.ReplyAddr = srv.URL
+
+	// a cascade without nodes, so that the reply arrives as it was sent
+	mc := mixnet.NewMixnetClient(&mixnet.MixnetClientConfig{MessageLength: replyLength})
+	deliveryAddr := bytes.Repeat([]byte{7}, mixnet.ReplyAddrLength)
+	request := func(addr MailboxAddr, after uint64) (*mixnet.ReplySecret, []byte) {
+		replyBlock, secret, err := mc.NewReplyBlock(0, deliveryAddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := SealReplyRequest(&pub, addr, after, replyBlock, replyLength)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return secret, msg
+	}
+	open := func(secret *mixnet.ReplySecret, reply []byte) (*Message, bool) {
+		t.Helper()
+		if !bytes.Equal(reply[:mixnet.ReplyAddrLength], deliveryAddr) {
+			t.Fatalf("reply delivered to %x", reply[:mixnet.ReplyAddrLength])
+		}
+		payload, err := secret.Open(reply)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, complete, err := OpenReply(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg, complete
+	}
+
+	var addr, empty MailboxAddr
+	addr[0] = 1
+	empty[0] = 2
+	long := bytes.Repeat([]byte{1}, MaxPayloadLength(replyLength))
+	var msgs [][]byte
+	for _, contents := range [][]byte{[]byte("first"), long} {
+		msg, err := SealPublish(&pub, addr, contents, replyLength)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+	// the mail in the same batch is included
+	secret, req1 := request(addr, 0)
+	_, req2 := request(addr, 1)
+	_, req3 := request(empty, 0)
+	if err := s.Receive(append(msgs, req1, req2, req3)); err != nil {
+		t.Fatal(err)
+	}
+	if len(replies) != 3 {
+		t.Fatalf("sent %d replies, want 3", len(replies))
+	}
+	// without nodes every reply opens with any secret; replies are shuffled
+	got := map[string]bool{}
+	for _, reply := range replies {
+		msg, complete := open(secret, reply)
+		switch {
+		case msg == nil && complete:
+			got["none"] = true
+		case msg != nil && msg.ID == 1 && string(msg.Contents) == "first" && complete:
+			got["first"] = true
+		case msg != nil && msg.ID == 2 && bytes.Equal(msg.Contents, long) && complete:
+			got["second"] = true
+		}
+	}
+	if !got["none"] || !got["first"] || !got["second"] {
+		t.Errorf("opened %v", got)
+	}
+}
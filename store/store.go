@@ -0,0 +1,282 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/yunwilliamyu/contact-trace-mixnet/mixnet"
+	"github.com/yunwilliamyu/contact-trace-mixnet/mixnet/pb"
+	"github.com/yunwilliamyu/contact-trace-mixnet/notifier"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/nacl/box"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// AddrSize is the size of mailbox addresses, which are blinded curve points.
+const AddrSize = 32
+
+type MailboxAddr [AddrSize]byte
+
+// Message is a message stored in a mailbox. IDs increase in order of receipt
+// within a mailbox, and start at 1.
+type Message struct {
+	ID         uint64
+	Contents   []byte
+	ReceivedAt int64 // unix time in seconds
+}
+
+// Mail is a message to be stored in a mailbox.
+type Mail struct {
+	Addr     MailboxAddr
+	Contents []byte
+}
+
+type DB interface {
+	// Put stores a batch of mail atomically.
+	Put(batch []Mail) error
+	// Apply stores a batch of mail and records subscriptions (see Subscribe)
+	// in one transaction.
+	Apply(batch []Mail, subs []Subscription) error
+	// After returns the messages in the mailbox with IDs larger than after,
+	// in order of receipt.
+	After(addr MailboxAddr, after uint64) ([]*Message, error)
+
+	// Subscribe records subscriptions, setting their RenewedAt. New
+	// subscriptions start at the last message in the mailbox. Existing
+	// subscriptions with the same mailbox and renewal ID are renewed and keep
+	// their marker and forwarding address.
+	Subscribe(subs []Subscription) error
+	Subscriptions() ([]*Subscription, error)
+	// SetMarker records that the messages up to marker were forwarded.
+	SetMarker(sub *Subscription, marker uint64) error
+	// ExpireSubscriptions deletes subscriptions renewed before cutoff.
+	ExpireSubscriptions(cutoff time.Time) (int, error)
+}
+
+// Store is the database store v1. It receives the output of the last mixnet
+// node, and keeps the messages in mailboxes.
+type Store struct {
+	publicKey     [32]byte
+	privateKey    [32]byte
+	messageLength int
+	db            DB
+	fragments     *mixnet.Reassembler
+
+	// ReplyAddr is the address of the mixnet node with idx 0, which replies
+	// are sent to, see reply.go.
+	ReplyAddr string
+	// Notifier forwards mail to subscribers, see RunForwarder.
+	Notifier Notifier
+	// SubscriptionTTL is how long subscriptions last unless renewed.
+	SubscriptionTTL time.Duration
+}
+
+func deriveKeys(masterKey string) (publicKey, privateKey [32]byte) {
+	deriver := hkdf.New(sha256.New, []byte(masterKey), nil, []byte("STORE_KEY"))
+	pub, priv, err := box.GenerateKey(deriver)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return *pub, *priv
+}
+
+// NewStore returns a store for messages of messageLength, which is the
+// MessageLength of the mixnet.
+func NewStore(masterKey string, messageLength int, db DB) *Store {
+	s := &Store{messageLength: messageLength, db: db}
+	s.publicKey, s.privateKey = deriveKeys(masterKey)
+	s.fragments = &mixnet.Reassembler{}
+	return s
+}
+
+// PubKey returns the public key of the store with the given master key.
+func PubKey(masterKey string) [32]byte {
+	publicKey, _ := deriveKeys(masterKey)
+	return publicKey
+}
+
+// SealPublish returns a publish envelope for the mixnet, which deposits
+// contents in the mailbox at addr.
+func SealPublish(storePublicKey *[32]byte, addr MailboxAddr, contents []byte, messageLength int) ([]byte, error) {
+	return NewPublish(addr, contents).Seal(storePublicKey, messageLength)
+}
+
+// SealSubscribe returns a subscription envelope for the mixnet, which makes
+// the store forward the mail at addr to forwardAddr, a sealed dead drop
+// address. Sending it again with the same renewalID renews the subscription.
+func SealSubscribe(storePublicKey *[32]byte, addr MailboxAddr, renewalID []byte, forwardAddr []byte, messageLength int) ([]byte, error) {
+	if len(renewalID) != RenewalIDSize {
+		return nil, fmt.Errorf("invalid renewal ID length: %d, expected %d", len(renewalID), RenewalIDSize)
+	}
+	return NewSubscribe(addr, renewalID, forwardAddr).Seal(storePublicKey, messageLength)
+}
+
+// SendPayload sends contents of any size to the mailbox at addr, as fragment
+// envelopes through one cascade of mc, which the store reassembles.
+func SendPayload(mc *mixnet.MixnetClient, storePublicKey *[32]byte, addr MailboxAddr, contents []byte, messageLength int) error {
+	payload := append(append([]byte(nil), addr[:]...), contents...)
+	return mc.SendPayload(payload, MaxPayloadLength(messageLength), func(fragment []byte) ([]byte, error) {
+		return NewFragment(addr, fragment).Seal(storePublicKey, messageLength)
+	})
+}
+
+// payloadMail returns the mail in the payloads reassembled from fragment
+// envelopes, each a mailbox address followed by the mail.
+func payloadMail(payloads [][]byte) []Mail {
+	var batch []Mail
+	for _, p := range payloads {
+		if len(p) < AddrSize {
+			log.Printf("received reassembled payload without mailbox address")
+			continue
+		}
+		var addr MailboxAddr
+		copy(addr[:], p)
+		batch = append(batch, Mail{Addr: addr, Contents: p[AddrSize:]})
+	}
+	return batch
+}
+
+// Receive stores a batch of envelopes from the last mixnet node. Invalid
+// envelopes are skipped. It can be used as the PushHandler of the mixnet node
+// with idx 0.
+func (s *Store) Receive(msgs [][]byte) error {
+	var batch []Mail
+	var subs []Subscription
+	var fragments [][]byte
+	var replyRequests []replyRequest
+	for _, msg := range msgs {
+		e, err := OpenEnvelope(&s.publicKey, &s.privateKey, msg, s.messageLength)
+		if err != nil {
+			log.Printf("received invalid message: %s", err.Error())
+			continue
+		}
+		switch e.Type {
+		case EnvelopePublish:
+			batch = append(batch, Mail{Addr: e.Addr, Contents: e.Payload})
+		case EnvelopeSubscribe:
+			if len(e.Payload) != RenewalIDSize+notifier.SealedAddressV1Size {
+				log.Printf("received subscription with invalid forwarding address")
+				continue
+			}
+			subs = append(subs, Subscription{
+				Addr:        e.Addr,
+				RenewalID:   e.Payload[:RenewalIDSize],
+				ForwardAddr: e.Payload[RenewalIDSize:],
+			})
+		case EnvelopeReplyRequest:
+			req, err := parseReplyRequest(e)
+			if err != nil {
+				log.Printf("received invalid reply request: %s", err.Error())
+				continue
+			}
+			replyRequests = append(replyRequests, req)
+		case EnvelopeFragment:
+			fragments = append(fragments, e.Payload)
+		default:
+			log.Printf("received message of unknown type %d", e.Type)
+		}
+	}
+	// Nothing is stored if this fails, so the last node can push the batch
+	// again without duplicating mail. Payloads which cannot be stored are
+	// kept by the Reassembler until then.
+	if err := s.fragments.Collect(fragments, func(payloads [][]byte) error {
+		batch := append(batch, payloadMail(payloads)...)
+		if len(batch) == 0 && len(subs) == 0 {
+			return nil
+		}
+		return s.db.Apply(batch, subs)
+	}); err != nil {
+		return err
+	}
+	if len(replyRequests) > 0 {
+		// after storing the batch, so that the replies can include its mail
+		s.sendReplies(replyRequests)
+	}
+	return nil
+}
+
+// After returns the messages in the mailbox at addr after the one with ID
+// after, or all of them if after is 0.
+func (s *Store) After(addr MailboxAddr, after uint64) ([]*Message, error) {
+	return s.db.After(addr, after)
+}
+
+// ServeReceive accepts batches in the same formats as mixnet nodes, so that
+// the store can be the OutputAddr of the mixnet.
+func (s *Store) ServeReceive(rw http.ResponseWriter, req *http.Request) {
+	mixnet.ServeOnions(rw, req, s.messageLength, func(putReq *pb.PutOnionsRequest) error {
+		return s.Receive(putReq.Msgs)
+	})
+}
+
+// ServeMessages answers GET /v0/messages?mailbox=<hex>&after=<ID> with the
+// JSON list of messages in the mailbox after the given one.
+func (s *Store) ServeMessages(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(rw, "only GET allowed", http.StatusBadRequest)
+		return
+	}
+	var addr MailboxAddr
+	rawAddr, err := hex.DecodeString(req.FormValue("mailbox"))
+	if err != nil || len(rawAddr) != AddrSize {
+		http.Error(rw, "invalid mailbox address", http.StatusBadRequest)
+		return
+	}
+	copy(addr[:], rawAddr)
+	var after uint64
+	if a := req.FormValue("after"); a != "" {
+		after, err = strconv.ParseUint(a, 10, 64)
+		if err != nil {
+			http.Error(rw, "invalid message ID", http.StatusBadRequest)
+			return
+		}
+	}
+	messages, err := s.db.After(addr, after)
+	if err != nil {
+		log.Printf("error while reading mailbox: %s", err.Error())
+		http.Error(rw, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if messages == nil {
+		messages = []*Message{}
+	}
+	text, err := json.Marshal(messages)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(text)
+}
+
+func (s *Store) ServePubkey(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "application/octet-stream")
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(s.publicKey[:])
+}
+
+func (s *Store) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/v0/receive", http.HandlerFunc(s.ServeReceive))
+	mux.Handle("/v0/messages", http.HandlerFunc(s.ServeMessages))
+	mux.Handle("/v0/pubkey", http.HandlerFunc(s.ServePubkey))
+	return mux
+}
+
+func (s *Store) Run(listenAddr string) error {
+	srv := &http.Server{
+		Addr:    listenAddr,
+		Handler: s.Handler(),
+	}
+	return srv.ListenAndServe()
+}
+
+// stamp returns the receipt time for messages stored now.
+func stamp() int64 {
+	return time.Now().Unix()
+}
@@ -0,0 +1,293 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/yunwilliamyu/contact-trace-mixnet/mixnet"
+	"github.com/yunwilliamyu/contact-trace-mixnet/mixnet/pb"
+	"github.com/yunwilliamyu/contact-trace-mixnet/notifier"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const messageLength = 100
+
+func TestReceive(t *testing.T) {
+	s := NewStore("test", messageLength, &InMemoryDB{})
+	pub := PubKey("test")
+	var addr MailboxAddr
+	addr[0] = 1
+
+	var batch [][]byte
+	for i := 0; i < 3; i++ {
+		msg, err := SealPublish(&pub, addr, []byte{byte(i)}, messageLength)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msg) != messageLength {
+			t.Fatalf("publish envelope is %d bytes long, want %d", len(msg), messageLength)
+		}
+		batch = append(batch, msg)
+	}
+	// not for this store
+	otherPub := PubKey("other")
+	msg, err := SealPublish(&otherPub, addr, []byte{0}, messageLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch = append(batch, msg, []byte("short"))
+	if err := s.Receive(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+	get := func(after uint64) []*Message {
+		resp, err := http.Get(fmt.Sprintf("%s/v0/messages?mailbox=%s&after=%d", srv.URL, hex.EncodeToString(addr[:]), after))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status %d from /v0/messages", resp.StatusCode)
+		}
+		var messages []*Message
+		if err := json.NewDecoder(resp.Body).Decode(&messages); err != nil {
+			t.Fatal(err)
+		}
+		return messages
+	}
+	messages := get(0)
+	if len(messages) != 3 {
+		t.Fatalf("got %d messages, want 3", len(messages))
+	}
+	for i, msg := range messages {
+		if msg.Contents[0] != byte(i) {
+			t.Errorf("message %d has contents %v", i, msg.Contents)
+		}
+	}
+	if messages := get(messages[1].ID); len(messages) != 1 || messages[0].Contents[0] != 2 {
+		t.Errorf("got %v after the second message", messages)
+	}
+}
+
+type fakeNotifier struct {
+	sent    []string
+	failing bool
+}
+
+func (fn *fakeNotifier) Notify(ctx context.Context, address []byte, msg []byte) error {
+	if fn.failing {
+		return errors.New("notifier is down")
+	}
+	fn.sent = append(fn.sent, fmt.Sprintf("%s:%d", bytes.TrimRight(address, "\x00"), msg[0]))
+	return nil
+}
+
+func TestForward(t *testing.T) {
+	// long enough for sealed dead drop addresses
+	const forwardLength = 200
+	db := &InMemoryDB{}
+	s := NewStore("test", forwardLength, db)
+	fn := &fakeNotifier{}
+	s.Notifier = fn
+	pub := PubKey("test")
+	var addr MailboxAddr
+	publish := func(i int) []byte {
+		msg, err := SealPublish(&pub, addr, []byte{byte(i)}, forwardLength)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+	forward := func(want string) {
+		t.Helper()
+		fn.sent = nil
+		if err := s.Forward(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprint(fn.sent); got != want {
+			t.Errorf("forwarded %s, want %s", got, want)
+		}
+	}
+
+	renewalID := bytes.Repeat([]byte{1}, RenewalIDSize)
+	subscribe := func(forwardAddr string) []byte {
+		// stands in for a sealed dead drop address of the polling gateway
+		sealed := make([]byte, notifier.SealedAddressV1Size)
+		copy(sealed, forwardAddr)
+		msg, err := SealSubscribe(&pub, addr, renewalID, sealed, forwardLength)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+	sub := subscribe("gw-sealed-addr1")
+	if len(sub) != forwardLength {
+		t.Fatalf("subscription envelope is %d bytes long, want %d", len(sub), forwardLength)
+	}
+	if err := s.Receive([][]byte{publish(0), sub}); err != nil {
+		t.Fatal(err)
+	}
+	// mail from before the subscription is not forwarded
+	forward("[]")
+	if err := s.Receive([][]byte{publish(1)}); err != nil {
+		t.Fatal(err)
+	}
+	forward("[gw-sealed-addr1:1]")
+	forward("[]")
+
+	// renewals do not forward the mailbox again, and keep the address
+	if err := s.Receive([][]byte{subscribe("gw-sealed-addr2")}); err != nil {
+		t.Fatal(err)
+	}
+	forward("[]")
+
+	// failed deliveries are retried
+	if err := s.Receive([][]byte{publish(2), publish(3)}); err != nil {
+		t.Fatal(err)
+	}
+	fn.failing = true
+	forward("[]")
+	fn.failing = false
+	forward("[gw-sealed-addr1:2 gw-sealed-addr1:3]")
+
+	s.SubscriptionTTL = -time.Hour
+	if n, err := s.ExpireSubscriptions(); err != nil || n != 1 {
+		t.Fatalf("ExpireSubscriptions expired %d (error %v), want 1", n, err)
+	}
+	if err := s.Receive([][]byte{publish(4)}); err != nil {
+		t.Fatal(err)
+	}
+	forward("[]")
+}
+
+// failingDB fails to store mail while fail is set.
+type failingDB struct {
+	DB
+	fail bool
+}
+
+func (db *failingDB) Put(batch []Mail) error {
+	return db.Apply(batch, nil)
+}
+
+func (db *failingDB) Apply(batch []Mail, subs []Subscription) error {
+	if db.fail {
+		return errors.New("disk full")
+	}
+	return db.DB.Apply(batch, subs)
+}
+
+func TestReceiveRetry(t *testing.T) {
+	const retryLength = 200
+	db := &failingDB{DB: &InMemoryDB{}}
+	s := NewStore("test", retryLength, db)
+	pub := PubKey("test")
+	var addr MailboxAddr
+	seal := func(e *Envelope) []byte {
+		msg, err := e.Seal(&pub, retryLength)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+	payload := append(addr[:], bytes.Repeat([]byte{2}, 300)...)
+	fragments, err := mixnet.Fragment(payload, MaxPayloadLength(retryLength))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs := [][]byte{
+		seal(NewPublish(addr, []byte{1})),
+		seal(NewSubscribe(addr, bytes.Repeat([]byte{1}, RenewalIDSize), make([]byte, notifier.SealedAddressV1Size))),
+	}
+	for _, f := range fragments {
+		msgs = append(msgs, seal(NewFragment(addr, f)))
+	}
+
+	db.fail = true
+	if err := s.Receive(msgs); err == nil {
+		t.Fatal("Receive did not return the error of the DB")
+	}
+	db.fail = false
+	if err := s.Receive(msgs); err != nil {
+		t.Fatal(err)
+	}
+	messages, err := s.After(addr, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 || len(messages[0].Contents) != 1 || len(messages[1].Contents) != 300 {
+		t.Errorf("got %d messages, want the mail and the payload once", len(messages))
+	}
+	if subs, err := db.Subscriptions(); err != nil || len(subs) != 1 {
+		t.Errorf("got %d subscriptions (error %v), want 1", len(subs), err)
+	}
+}
+
+func TestSendPayload(t *testing.T) {
+	const payloadLength = 200
+	db := &failingDB{DB: &InMemoryDB{}}
+	s := NewStore("test", payloadLength, db)
+	pub := PubKey("test")
+
+	// two cascades without layers, so that the envelopes arrive as they are
+	received := make(map[int][][]byte)
+	conf := &mixnet.MixnetClientConfig{MessageLength: payloadLength}
+	for i := 0; i < 2; i++ {
+		i := i
+		srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			mixnet.ServeOnions(rw, req, payloadLength, func(putReq *pb.PutOnionsRequest) error {
+				received[i] = append(received[i], putReq.Msgs...)
+				return nil
+			})
+		}))
+		defer srv.Close()
+		conf.Cascades = append(conf.Cascades, mixnet.Cascade{Addr: srv.URL})
+	}
+	mc := mixnet.NewMixnetClient(conf)
+
+	var addr MailboxAddr
+	addr[0] = 1
+	contents := bytes.Repeat([]byte("contents"), 50)
+	if err := SendPayload(mc, &pub, addr, contents, payloadLength); err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 1 {
+		t.Fatalf("fragments sent through %d cascades, want 1", len(received))
+	}
+	var msgs [][]byte
+	for _, m := range received {
+		msgs = m
+	}
+	if len(msgs) < 3 {
+		t.Fatalf("payload sent as %d messages, want several", len(msgs))
+	}
+
+	// fragments arrive in any order and batches, and are pushed again if
+	// they could not be stored
+	if err := s.Receive(msgs[1:]); err != nil {
+		t.Fatal(err)
+	}
+	db.fail = true
+	if err := s.Receive(msgs[:1]); err == nil {
+		t.Fatal("Receive did not return the error of the DB")
+	}
+	db.fail = false
+	if err := s.Receive(msgs[:1]); err != nil {
+		t.Fatal(err)
+	}
+	messages, err := s.After(addr, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || !bytes.Equal(messages[0].Contents, contents) {
+		t.Errorf("got %d messages, want the payload once", len(messages))
+	}
+}